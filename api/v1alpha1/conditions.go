@@ -0,0 +1,38 @@
+package v1alpha1
+
+// Condition types set on every llm.example.com resource.
+const (
+	// ConditionReady is True once the resource is serving (InferenceService,
+	// KVCachePool) or has produced its result (LLMInferenceJob).
+	ConditionReady = "Ready"
+	// ConditionProgressing is True while the operator is rolling out a
+	// change or a job is still running.
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded is True when the resource cannot reach its desired
+	// state without outside help.
+	ConditionDegraded = "Degraded"
+	// ConditionCachePoolReady mirrors the Ready condition of the KVCachePool
+	// an InferenceService references. It is absent without a cachePoolRef.
+	ConditionCachePoolReady = "CachePoolReady"
+)
+
+// Condition reasons.
+const (
+	ReasonAvailable                = "Available"
+	ReasonDeploymentUnavailable    = "DeploymentUnavailable"
+	ReasonCacheNodesUnavailable    = "CacheNodesUnavailable"
+	ReasonCachePoolNotFound        = "CachePoolNotFound"
+	ReasonCachePoolNotReady        = "CachePoolNotReady"
+	ReasonRolloutInProgress        = "RolloutInProgress"
+	ReasonRolloutComplete          = "RolloutComplete"
+	ReasonRolloutStalled           = "RolloutStalled"
+	ReasonApplyFailed              = "ApplyFailed"
+	ReasonInvalidSpec              = "InvalidSpec"
+	ReasonAsExpected               = "AsExpected"
+	ReasonInferenceServiceNotFound = "InferenceServiceNotFound"
+	ReasonJobRunning               = "JobRunning"
+	ReasonJobSucceeded             = "JobSucceeded"
+	ReasonJobFailed                = "JobFailed"
+	ReasonBackoffLimitExceeded     = "BackoffLimitExceeded"
+	ReasonDeadlineExceeded         = "DeadlineExceeded"
+)
@@ -5,6 +5,7 @@ import (
 )
 
 // InferenceServiceSpec defines the desired state of InferenceService
+// +kubebuilder:validation:XValidation:rule="!has(self.rateLimit) || !has(self.rateLimit.shared) || !self.rateLimit.shared || has(self.cachePoolRef)",message="rateLimit.shared requires cachePoolRef"
 type InferenceServiceSpec struct {
 
 	// logical name of the model service routes to
@@ -19,18 +20,249 @@ type InferenceServiceSpec struct {
 	// +kubebuilder:default=4
 	MaxConcurrency int32 `json:"maxConcurrency,omitempty"`
 
+	// maximum number of requests a router pod queues while all
+	// MaxConcurrency slots are busy; further requests get 429
+	// +kubebuilder:default=16
+	// +kubebuilder:validation:Minimum=0
+	MaxQueueDepth int32 `json:"maxQueueDepth,omitempty"`
+
+	// how long a queued request waits for a free slot before
+	// the router gives up with 503
+	// +optional
+	MaxQueueWait *metav1.Duration `json:"maxQueueWait,omitempty"`
+
+	// how long a terminating router pod keeps serving after it
+	// reports not-ready, so endpoints can stop routing to it
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=0
+	DrainPeriodSeconds int32 `json:"drainPeriodSeconds,omitempty"`
+
+	// how long a router pod waits for in-flight requests to
+	// finish after draining before it is stopped
+	// +kubebuilder:default=30
+	// +kubebuilder:validation:Minimum=1
+	ShutdownTimeoutSeconds int32 `json:"shutdownTimeoutSeconds,omitempty"`
+
 	// CachePoolRef points to a KVCachePool the router should use.
 	CachePoolRef string `json:"cachePoolRef,omitempty"`
+
+	// Backends lists the model-server endpoints (host:port or URL) the
+	// router forwards generation requests to.
+	// +optional
+	Backends []string `json:"backends,omitempty"`
+
+	// Scheduling declares the priority tiers and tenant weights the
+	// router orders queued requests by. Without it, requests are queued
+	// as one tier in which every tenant has the same weight.
+	// +optional
+	Scheduling *SchedulingSpec `json:"scheduling,omitempty"`
+
+	// Auth, if set, makes the router require an API key from every
+	// inference request.
+	// +optional
+	Auth *AuthSpec `json:"auth,omitempty"`
+
+	// RateLimit, if set, caps each tenant's request rate and estimated
+	// token throughput.
+	// +optional
+	RateLimit *RateLimitSpec `json:"rateLimit,omitempty"`
+
+	// Batching, if set, makes the router group non-streaming requests
+	// into micro-batches sent to a backend's /generate/batch endpoint.
+	// +optional
+	Batching *BatchingSpec `json:"batching,omitempty"`
+
+	// Audit, if set, makes every router pod append each request and
+	// response to a rotating JSONL log that the replay command can
+	// re-issue.
+	// +optional
+	Audit *AuditSpec `json:"audit,omitempty"`
+}
+
+// SchedulingSpec configures how the router admits queued requests: by
+// priority tier first, then fairly across the tenants within a tier.
+// +kubebuilder:validation:XValidation:rule="!has(self.defaultTier) || (has(self.tiers) && self.defaultTier in self.tiers)",message="defaultTier must be one of tiers"
+type SchedulingSpec struct {
+	// priority tiers, highest first. A request picks its tier with the
+	// X-Priority header. A queued request is always admitted before
+	// those of lower tiers, and when the queue is full a new request
+	// pushes out the last queued request of a lower tier.
+	// +kubebuilder:validation:MaxItems=8
+	// +kubebuilder:validation:items:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:items:MaxLength=63
+	// +listType=set
+	// +optional
+	Tiers []string `json:"tiers,omitempty"`
+
+	// tier for requests without a known X-Priority header;
+	// defaults to the lowest tier
+	// +optional
+	DefaultTier string `json:"defaultTier,omitempty"`
+
+	// share of the router's slots each tenant (the X-Tenant header)
+	// gets within a tier while requests are queued; unlisted tenants
+	// have weight 1
+	// +kubebuilder:validation:MaxItems=256
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Tenants []TenantWeight `json:"tenants,omitempty"`
+}
+
+// TenantWeight is one tenant's fair-queueing weight.
+type TenantWeight struct {
+	// tenant name as sent in the X-Tenant header
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]+$`
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	// relative share; a tenant with weight 3 is admitted three times
+	// as often as one with weight 1 when both have requests queued
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// AuthSpec configures API-key authentication on the router.
+type AuthSpec struct {
+	// SecretName names a Secret in the InferenceService's namespace
+	// holding the accepted API keys. Each key of the Secret is a tenant
+	// name; its value lists that tenant's API keys, one per line, as the
+	// hex SHA-256 of the key, optionally followed by the priority tier
+	// the key's requests get. Changes to the Secret are picked up by
+	// running router pods.
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+}
+
+// RateLimitSpec configures per-tenant token-bucket rate limits. Requests
+// over a limit get 429 with a Retry-After header.
+type RateLimitSpec struct {
+	// limits applied to every tenant not listed in tenants
+	RateLimitValues `json:",inline"`
+
+	// per-tenant limits; a listed tenant's values replace the
+	// defaults entirely
+	// +kubebuilder:validation:MaxItems=256
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Tenants []TenantRateLimit `json:"tenants,omitempty"`
+
+	// keep the counters in the KVCachePool named by cachePoolRef so
+	// the limits hold across router replicas; otherwise each router
+	// pod enforces them on its own
+	// +optional
+	Shared bool `json:"shared,omitempty"`
+}
+
+// RateLimitValues is one set of rate limits. Zero means unlimited.
+type RateLimitValues struct {
+	// steady requests per second
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RequestsPerSecond int32 `json:"requestsPerSecond,omitempty"`
+
+	// requests that may arrive at once above the steady rate;
+	// defaults to requestsPerSecond
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+
+	// estimated prompt plus completion tokens per minute
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TokensPerMinute int64 `json:"tokensPerMinute,omitempty"`
+}
+
+// TenantRateLimit is the rate limit of one tenant.
+type TenantRateLimit struct {
+	// tenant name as sent in the X-Tenant header
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9._-]+$`
+	// +kubebuilder:validation:MaxLength=63
+	Name string `json:"name"`
+
+	RateLimitValues `json:",inline"`
+}
+
+// BatchingSpec configures the router's dynamic micro-batching.
+type BatchingSpec struct {
+	// largest number of requests sent upstream in one batch; a
+	// batch is sent as soon as it is full. Every waiting request
+	// holds a concurrency slot, so the router caps this at
+	// MaxConcurrency.
+	// +kubebuilder:default=8
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=256
+	MaxBatchSize int32 `json:"maxBatchSize,omitempty"`
+
+	// how long the first request of a batch waits for others
+	// before the batch is sent anyway
+	// +kubebuilder:default="10ms"
+	// +optional
+	MaxWait *metav1.Duration `json:"maxWait,omitempty"`
+}
+
+// AuditSpec configures the router's request audit log. Each router pod
+// writes requests-<pod>.jsonl with the time, endpoint, tenant, priority
+// tier, backend, status, latency, request body and response of every
+// request.
+type AuditSpec struct {
+	// replace prompts, including chat messages, with their length
+	// +optional
+	RedactPrompts bool `json:"redactPrompts,omitempty"`
+
+	// replace generated text with its length
+	// +optional
+	RedactOutputs bool `json:"redactOutputs,omitempty"`
+
+	// add a SHA-256 prefix of redacted text, so identical prompts
+	// can still be told apart
+	// +optional
+	HashRedacted bool `json:"hashRedacted,omitempty"`
+
+	// size in MiB at which a log file is rotated
+	// +kubebuilder:default=100
+	// +kubebuilder:validation:Minimum=1
+	MaxSizeMB int32 `json:"maxSizeMB,omitempty"`
+
+	// rotated files kept besides the current one
+	// +kubebuilder:default=5
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	MaxFiles int32 `json:"maxFiles,omitempty"`
+
+	// ClaimName names a PersistentVolumeClaim in the InferenceService's
+	// namespace to write the logs to. It must allow every router pod
+	// to mount it, typically ReadWriteMany. Without it the logs go to
+	// an emptyDir sized for the rotated files and are lost with the
+	// pod.
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
 }
 
 // InferenceServiceStatus defines the observed state of InferenceService.
 type InferenceServiceStatus struct {
 	// how many router pods are actually ready.
 	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+	// observedGeneration is the metadata.generation the status was computed
+	// from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// conditions report Ready, Progressing and Degraded.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // InferenceService is the Schema for the inferenceservices API
 type InferenceService struct {
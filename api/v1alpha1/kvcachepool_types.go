@@ -1,33 +1,93 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// KVCacheWorkload is the kind of workload that runs the cache nodes.
+// +kubebuilder:validation:Enum=Deployment;StatefulSet
+type KVCacheWorkload string
+
+const (
+	KVCacheWorkloadDeployment  KVCacheWorkload = "Deployment"
+	KVCacheWorkloadStatefulSet KVCacheWorkload = "StatefulSet"
+)
+
+// KVCachePersistence configures a PersistentVolumeClaim per cache node.
+type KVCachePersistence struct {
+	// Size is the requested storage per cache node.
+	Size resource.Quantity `json:"size"`
+
+	// StorageClassName is the StorageClass for the claims. If omitted,
+	// the cluster default is used.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
 // KVCachePoolSpec defines the desired state of KVCachePool
+// +kubebuilder:validation:XValidation:rule="!has(self.persistence) || (has(self.workload) && self.workload == 'StatefulSet')",message="persistence requires workload StatefulSet"
 type KVCachePoolSpec struct {
 	// TotalMemoryGB is the total memory (across all replicas) intended for KV cache.
+	// It is split evenly across replicas to size each node's Redis maxmemory
+	// and container memory, unless MemoryPerReplicaMB is set.
+	// +kubebuilder:validation:Minimum=1
 	TotalMemoryGB int32 `json:"totalMemoryGB"`
 
+	// MemoryPerReplicaMB, if set, is each node's Redis maxmemory in MiB,
+	// used instead of splitting TotalMemoryGB. The pod template then no
+	// longer depends on replicas, so scaling the pool adds or removes
+	// nodes without restarting the others and their shards stay put.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MemoryPerReplicaMB *int32 `json:"memoryPerReplicaMB,omitempty"`
+
 	// Replicas is the desired number of cache nodes.
 	// If omitted, defaults to 1.
 	// +kubebuilder:default=1
 	Replicas *int32 `json:"replicas,omitempty"`
 
-	// Strategy is the cache strategy, e.g. "lru", "lfu", "rr".
-	// Right now it's just metadata; later it will drive eviction/placement.
+	// Strategy is the cache eviction strategy: "lru" (least recently used),
+	// "lfu" (least frequently used) or "rr" (random replacement).
 	// +kubebuilder:default="lru"
+	// +kubebuilder:validation:Enum=lru;lfu;rr
 	Strategy string `json:"strategy,omitempty"`
+
+	// Workload is the kind of workload that runs the cache nodes.
+	// StatefulSet gives every node a stable ordinal hostname through the
+	// headless <pool>-cache Service, so shard placement survives restarts,
+	// and scales down from the highest ordinal one node at a time.
+	// +kubebuilder:default=Deployment
+	Workload KVCacheWorkload `json:"workload,omitempty"`
+
+	// Persistence gives every cache node its own PersistentVolumeClaim and
+	// enables Redis append-only persistence. Requires the StatefulSet
+	// workload.
+	// +optional
+	Persistence *KVCachePersistence `json:"persistence,omitempty"`
 }
 
 // KVCachePoolStatus defines the observed state of KVCachePool.
 type KVCachePoolStatus struct {
 	// ReadyReplicas is how many cache nodes are currently ready.
 	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// observedGeneration is the metadata.generation the status was computed
+	// from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// conditions report Ready, Progressing and Degraded.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // KVCachePool is the Schema for the kvcachepools API
 type KVCachePool struct {
@@ -1,22 +1,250 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// DefaultRunnerImage runs cmd/runner.
+const DefaultRunnerImage = "ghcr.io/vishalsanfran/llama-shepherd-runner:latest"
+
 // LLMInferenceJobSpec defines the desired state of LLMInferenceJob
+// +kubebuilder:validation:XValidation:rule="has(self.modelRef) || has(self.inferenceServiceRef)",message="one of modelRef or inferenceServiceRef is required"
+// +kubebuilder:validation:XValidation:rule="[has(self.prompt), has(self.promptFrom), has(self.batch)].filter(x, x).size() <= 1",message="prompt, promptFrom and batch are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="!has(self.deleteAfterTTL) || !self.deleteAfterTTL || has(self.ttlSecondsAfterFinished)",message="deleteAfterTTL requires ttlSecondsAfterFinished"
 type LLMInferenceJobSpec struct {
+	// Prompt is the single prompt to run. It is handed to the runner in an
+	// environment variable, never through a shell.
+	// +optional
 	Prompt string `json:"prompt,omitempty"`
+
+	// PromptFrom reads the single prompt from a key of a ConfigMap or
+	// Secret, mounted into the runner pod as a file.
+	// +optional
+	PromptFrom *PromptSource `json:"promptFrom,omitempty"`
+
+	// Batch runs the job over many prompts, sharded across the pods of an
+	// indexed Job, instead of the single prompt.
+	// +optional
+	Batch *LLMInferenceBatch `json:"batch,omitempty"`
+
+	// ModelRef names the model to run. Without inferenceServiceRef, the job
+	// is sent to an InferenceService in the same namespace serving this
+	// model.
+	// +optional
+	ModelRef string `json:"modelRef,omitempty"`
+
+	// InferenceServiceRef names the InferenceService, in the same namespace,
+	// to send the prompt to. With modelRef also set, it must serve that
+	// model.
+	// +optional
+	InferenceServiceRef string `json:"inferenceServiceRef,omitempty"`
+
+	// RunnerImage is the image of the pod that submits the prompt.
+	// +kubebuilder:default="ghcr.io/vishalsanfran/llama-shepherd-runner:latest"
+	// +optional
+	RunnerImage string `json:"runnerImage,omitempty"`
+
+	// APIKeySecretRef selects the API key the runner presents to an
+	// InferenceService that requires one.
+	// +optional
+	APIKeySecretRef *corev1.SecretKeySelector `json:"apiKeySecretRef,omitempty"`
+
+	// MaxTokens caps the number of tokens generated.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxTokens *int32 `json:"maxTokens,omitempty"`
+
+	// BackoffLimit is how many failed runner pods are retried before the
+	// job fails. For a batch job it counts failures across all shards.
+	// Defaults to 6, the Job default.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+
+	// ActiveDeadlineSeconds bounds how long the job may run, retries
+	// included, before it fails with reason DeadlineExceeded.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// TTLSecondsAfterFinished is how long a Succeeded or Failed job keeps
+	// its runner Job, pods and output ConfigMap. Unset keeps them until
+	// the job is deleted.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// DeleteAfterTTL deletes the LLMInferenceJob itself, with everything
+	// it owns, once ttlSecondsAfterFinished has passed, rather than only
+	// its runner Job.
+	// +optional
+	DeleteAfterTTL bool `json:"deleteAfterTTL,omitempty"`
+}
+
+// PromptSource selects a prompt stored in a ConfigMap or Secret.
+// +kubebuilder:validation:XValidation:rule="has(self.configMapKeyRef) != has(self.secretKeyRef)",message="exactly one of configMapKeyRef or secretKeyRef is required"
+type PromptSource struct {
+	// ConfigMapKeyRef selects a key of a ConfigMap.
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// SecretKeyRef selects a key of a Secret.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// LLMInferenceBatch describes a batch of prompts and where the results go.
+// Prompts come from exactly one of prompts, configMap or
+// persistentVolumeClaim; the latter two hold JSONL, one {"prompt": "..."}
+// object or JSON string per line.
+// +kubebuilder:validation:XValidation:rule="[has(self.prompts), has(self.configMap), has(self.persistentVolumeClaim)].filter(x, x).size() == 1",message="exactly one of prompts, configMap or persistentVolumeClaim is required"
+type LLMInferenceBatch struct {
+	// Prompts lists the prompts inline.
+	// +optional
+	Prompts []string `json:"prompts,omitempty"`
+
+	// ConfigMap reads the prompts from a key of a ConfigMap.
+	// +optional
+	ConfigMap *corev1.ConfigMapKeySelector `json:"configMap,omitempty"`
+
+	// PersistentVolumeClaim reads the prompts from a file on a claim.
+	// +optional
+	PersistentVolumeClaim *BatchVolumeFile `json:"persistentVolumeClaim,omitempty"`
+
+	// Output is where each shard writes its results, as
+	// <path>/shard-<index>.jsonl on the claim.
+	// +required
+	Output BatchVolumeFile `json:"output"`
+
+	// Shards is how many indexed pods split the prompts; shard i takes
+	// every prompt whose line number modulo shards is i.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000
+	// +optional
+	Shards int32 `json:"shards,omitempty"`
+
+	// Parallelism caps how many shards run at once. Defaults to shards.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Parallelism *int32 `json:"parallelism,omitempty"`
+}
+
+// BatchVolumeFile is a path on a PersistentVolumeClaim in the job's
+// namespace.
+type BatchVolumeFile struct {
+	// ClaimName names the PersistentVolumeClaim.
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+
+	// Path is relative to the root of the claim.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=1024
+	// +kubebuilder:validation:XValidation:rule="!self.startsWith('/') && !self.split('/').exists(p, p == '..')",message="path must be relative and must not contain .."
+	Path string `json:"path"`
+}
+
+// Shard phases.
+const (
+	ShardPending   = "Pending"
+	ShardRunning   = "Running"
+	ShardSucceeded = "Succeeded"
+	ShardFailed    = "Failed"
+)
+
+// LLMInferenceShardStatus is the progress of one shard of a batch job.
+type LLMInferenceShardStatus struct {
+	// Index is the shard's completion index.
+	Index int32 `json:"index"`
+
+	// Phase is Pending, Running, Succeeded or Failed.
+	Phase string `json:"phase"`
+
+	// Prompts is how many prompts the shard processed.
+	// +optional
+	Prompts int32 `json:"prompts,omitempty"`
+
+	// Errors is how many of those prompts failed.
+	// +optional
+	Errors int32 `json:"errors,omitempty"`
+
+	// Output is the shard's results file, as pvc://<claim>/<path>.
+	// +optional
+	Output string `json:"output,omitempty"`
 }
 
+// Job phases.
+const (
+	JobPhasePending   = "Pending"
+	JobPhaseRunning   = "Running"
+	JobPhaseSucceeded = "Succeeded"
+	JobPhaseFailed    = "Failed"
+)
+
 // LLMInferenceJobStatus defines the observed state of LLMInferenceJob.
 type LLMInferenceJobStatus struct {
-	Completed bool   `json:"completed,omitempty"`
-	Output    string `json:"output,omitempty"`
+	// Phase is Pending until a runner pod starts, then Running until the
+	// job Succeeded or Failed. Succeeded and Failed are final.
+	// +kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains why a Failed job failed, from the runner's
+	// termination message where there is one.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// CompletionTime is when the job Succeeded or Failed.
+	// ttlSecondsAfterFinished counts from here.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	Completed bool `json:"completed,omitempty"`
+
+	// Output is the generated text of a single-prompt job. Text longer
+	// than 2 KiB is cut, and outputTruncated and outputRef are set.
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// OutputTruncated is set when output holds only the start of the
+	// generated text.
+	// +optional
+	OutputTruncated bool `json:"outputTruncated,omitempty"`
+
+	// OutputRef points at the job's full output:
+	// configmap://<name>/<key> for a long generation, or
+	// pvc://<claim>/<path> for the results of a batch.
+	// +optional
+	OutputRef string `json:"outputRef,omitempty"`
+
+	// InferenceService is the InferenceService the job was sent to,
+	// resolved once from the spec so the runner pod never changes target.
+	// +optional
+	InferenceService string `json:"inferenceService,omitempty"`
+
+	// Shards reports each shard of a batch job, by index.
+	// +optional
+	Shards []LLMInferenceShardStatus `json:"shards,omitempty"`
+
+	// observedGeneration is the metadata.generation the status was computed
+	// from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// conditions report Ready, Progressing and Degraded.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // LLMInferenceJob is the Schema for the llminferencejobs API
 type LLMInferenceJob struct {
@@ -27,6 +27,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -37,6 +38,7 @@ import (
 
 	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
 	"github.com/vishalsanfran/llama-shepherd/internal/controller"
+	webhookv1alpha1 "github.com/vishalsanfran/llama-shepherd/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -61,6 +63,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var maxPromptBytes int
+	var jobHistoryLimit int
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -79,6 +83,10 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.IntVar(&maxPromptBytes, "max-prompt-bytes", webhookv1alpha1.DefaultMaxPromptBytes,
+		"The largest LLMInferenceJob prompt, in bytes, the validating webhook admits.")
+	flag.IntVar(&jobHistoryLimit, "llminferencejob-history-limit", 0,
+		"The number of finished LLMInferenceJobs to keep in each namespace, oldest deleted first. 0 keeps them all.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -178,13 +186,27 @@ func main() {
 		os.Exit(1)
 	}
 
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
 	if err := (&controller.LLMInferenceJobReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:       mgr.GetClient(),
+		Scheme:       mgr.GetScheme(),
+		PodLogs:      clientset.CoreV1(),
+		HistoryLimit: jobHistoryLimit,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "LLMInferenceJob")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err := webhookv1alpha1.SetupLLMInferenceJobWebhookWithManager(mgr, maxPromptBytes); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "LLMInferenceJob")
+			os.Exit(1)
+		}
+	}
 	if err := (&controller.InferenceServiceReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
@@ -0,0 +1,295 @@
+// Command replay re-issues the requests captured in router audit logs
+// against a router, at the recorded pace or scaled up or down, and reports
+// how the answers compare with the recorded ones. It is meant for
+// regression and load testing:
+//
+//	replay -target http://llama.example.com -speed 2 requests-*.jsonl*
+//
+// Records from several files, such as every router pod's log and its
+// rotated files, are merged in time order. Prompts and messages the router
+// redacted are replaced with filler text of the original length.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// record is the part of a router audit record replay needs.
+type record struct {
+	Time      time.Time       `json:"time"`
+	Endpoint  string          `json:"endpoint"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Tier      string          `json:"tier,omitempty"`
+	Status    int             `json:"status"`
+	LatencyMs float64         `json:"latencyMs"`
+	Request   json.RawMessage `json:"request,omitempty"`
+}
+
+type options struct {
+	target string
+	// speed scales the recorded gaps between requests: 2 replays twice
+	// as fast. Zero sends requests as fast as concurrency allows.
+	speed       float64
+	concurrency int
+	apiKey      string
+	timeout     time.Duration
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.target, "target", "", "base URL of the router to replay against")
+	flag.Float64Var(&opts.speed, "speed", 1,
+		"timing scale: 1 keeps the recorded pace, 2 is twice as fast, 0 sends requests back to back")
+	flag.IntVar(&opts.concurrency, "concurrency", 64, "maximum requests in flight")
+	flag.StringVar(&opts.apiKey, "api-key", os.Getenv("REPLAY_API_KEY"),
+		"API key sent as a bearer token (default $REPLAY_API_KEY)")
+	flag.DurationVar(&opts.timeout, "timeout", 5*time.Minute, "timeout for each request")
+	strict := flag.Bool("strict", false, "exit with status 1 if any request gets a different status than recorded")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -target URL [flags] audit.jsonl...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if opts.target == "" || flag.NArg() == 0 || opts.speed < 0 || opts.concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.target = strings.TrimRight(opts.target, "/")
+
+	records, skipped, err := loadRecords(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if skipped > 0 {
+		log.Printf("skipping %d records without a replayable request body", skipped)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	sum := replay(ctx, &http.Client{}, opts, records)
+	sum.print(os.Stdout)
+	if *strict && sum.mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadRecords reads the audit logs at paths and returns their records in
+// time order. Records without a request body, because it was not JSON or
+// too large to log, cannot be replayed and are only counted.
+func loadRecords(paths []string) ([]record, int, error) {
+	var records []record
+	skipped := 0
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		d := json.NewDecoder(f)
+		for {
+			var rec record
+			err := d.Decode(&rec)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				_ = f.Close()
+				return nil, 0, fmt.Errorf("%s: %w", path, err)
+			}
+			if rec.Request == nil || rec.Endpoint == "" {
+				skipped++
+				continue
+			}
+			records = append(records, rec)
+		}
+		_ = f.Close()
+	}
+	slices.SortStableFunc(records, func(a, b record) int { return a.Time.Compare(b.Time) })
+	return records, skipped, nil
+}
+
+// redactedRe matches a string the router's audit log redacted, which keeps
+// the original length in bytes.
+var redactedRe = regexp.MustCompile(`"\[redacted len=(\d+)(?: sha256=[0-9a-f]+)?\]"`)
+
+const filler = "lorem ipsum dolor sit amet "
+
+// fillRedacted replaces redacted strings in body with filler text of the
+// original length, so the replayed request costs about the same.
+func fillRedacted(body []byte) []byte {
+	return redactedRe.ReplaceAllFunc(body, func(m []byte) []byte {
+		n, _ := strconv.Atoi(string(redactedRe.FindSubmatch(m)[1]))
+		text := strings.Repeat(filler, n/len(filler)+1)[:n]
+		return []byte(strconv.Quote(text))
+	})
+}
+
+// result is the outcome of one replayed request.
+type result struct {
+	sent    bool
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// replay sends records to the target, spaced as they were recorded divided
+// by opts.speed. A request whose turn comes while opts.concurrency requests
+// are in flight waits for one of them to finish.
+func replay(ctx context.Context, client *http.Client, opts options, records []record) summary {
+	results := make([]result, len(records))
+	sem := make(chan struct{}, opts.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+send:
+	for i, rec := range records {
+		if opts.speed > 0 {
+			offset := time.Duration(float64(rec.Time.Sub(records[0].Time)) / opts.speed)
+			timer := time.NewTimer(time.Until(start.Add(offset)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				break send
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break send
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = send(ctx, client, opts, rec)
+		}()
+	}
+	wg.Wait()
+	return summarize(records, results, time.Since(start))
+}
+
+// send issues one recorded request and reads the whole response, so
+// streamed requests are timed to their last event.
+func send(ctx context.Context, client *http.Client, opts options, rec record) result {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, opts.target+rec.Endpoint,
+		bytes.NewReader(fillRedacted(rec.Request)))
+	if err != nil {
+		return result{sent: true, err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rec.Tenant != "" {
+		req.Header.Set("X-Tenant", rec.Tenant)
+	}
+	if rec.Tier != "" {
+		req.Header.Set("X-Priority", rec.Tier)
+	}
+	if opts.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return result{sent: true, err: err}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return result{sent: true, status: resp.StatusCode, latency: time.Since(start), err: err}
+}
+
+// summary compares a replay with the recording.
+type summary struct {
+	sent     int
+	failed   int
+	statuses map[int]int
+	// mismatched counts answered requests whose status differs from the
+	// recorded one.
+	mismatched int
+	// recorded and replayed are the latencies of the answered requests.
+	recorded []time.Duration
+	replayed []time.Duration
+	elapsed  time.Duration
+}
+
+func summarize(records []record, results []result, elapsed time.Duration) summary {
+	sum := summary{statuses: map[int]int{}, elapsed: elapsed}
+	for i, res := range results {
+		if !res.sent {
+			continue
+		}
+		sum.sent++
+		if res.err != nil {
+			sum.failed++
+			continue
+		}
+		sum.statuses[res.status]++
+		if res.status != records[i].Status {
+			sum.mismatched++
+		}
+		sum.recorded = append(sum.recorded, time.Duration(records[i].LatencyMs*float64(time.Millisecond)))
+		sum.replayed = append(sum.replayed, res.latency)
+	}
+	return sum
+}
+
+func (s summary) print(w io.Writer) {
+	rate := 0.0
+	if s.elapsed > 0 {
+		rate = float64(s.sent) / s.elapsed.Seconds()
+	}
+	_, _ = fmt.Fprintf(w, "replayed %d requests in %s (%.1f/s), %d failed\n",
+		s.sent, s.elapsed.Round(time.Millisecond), rate, s.failed)
+
+	codes := make([]int, 0, len(s.statuses))
+	for code := range s.statuses {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		_, _ = fmt.Fprintf(w, "  status %d: %d\n", code, s.statuses[code])
+	}
+	_, _ = fmt.Fprintf(w, "%d answered with a different status than recorded\n", s.mismatched)
+
+	if len(s.replayed) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "latency   %10s %10s %10s\n", "p50", "p90", "p99")
+	for _, row := range []struct {
+		name string
+		d    []time.Duration
+	}{{"recorded", s.recorded}, {"replayed", s.replayed}} {
+		_, _ = fmt.Fprintf(w, "%-9s %10s %10s %10s\n", row.name,
+			percentile(row.d, 0.5), percentile(row.d, 0.9), percentile(row.d, 0.99))
+	}
+}
+
+// percentile returns the q-th quantile of d by the nearest-rank method.
+func percentile(d []time.Duration, q float64) time.Duration {
+	sorted := slices.Clone(d)
+	slices.Sort(sorted)
+	i := int(float64(len(sorted))*q+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)].Round(time.Millisecond)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadRecordsMergesInTimeOrder(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"requests-a.jsonl": `{"time":"2026-01-01T00:00:02Z","endpoint":"/infer","status":200,"request":{"prompt":"c"}}
+{"time":"2026-01-01T00:00:00Z","endpoint":"/infer","status":200,"request":{"prompt":"a"}}
+`,
+		"requests-b.jsonl": `{"time":"2026-01-01T00:00:01Z","endpoint":"/infer","status":200,"request":{"prompt":"b"}}
+{"time":"2026-01-01T00:00:03Z","endpoint":"/infer","status":400,"requestBytes":8}
+`,
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	records, skipped, err := loadRecords(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 || len(records) != 3 {
+		t.Fatalf("loaded %d records and skipped %d, want 3 and 1", len(records), skipped)
+	}
+	for i, want := range []string{"a", "b", "c"} {
+		if got := string(records[i].Request); got != `{"prompt":"`+want+`"}` {
+			t.Fatalf("record %d request = %s, want prompt %q", i, got, want)
+		}
+	}
+}
+
+func TestFillRedacted(t *testing.T) {
+	body := []byte(`{"messages":[{"role":"user","content":"[redacted len=30 sha256=0123456789abcdef]"}],` +
+		`"prompt":"[redacted len=3]"}`)
+	var req struct {
+		Messages []struct{ Content string }
+		Prompt   string
+	}
+	if err := json.Unmarshal(fillRedacted(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Messages[0].Content) != 30 || req.Prompt != "lor" {
+		t.Fatalf("filled request = %+v", req)
+	}
+}
+
+func TestReplayKeepsScaledPace(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		arrived []time.Duration
+		headers []http.Header
+	)
+	start := time.Now()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		arrived = append(arrived, time.Since(start))
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+		if !strings.Contains(string(body), "prompt") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"output":"ok"}`))
+	}))
+	defer srv.Close()
+
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []record{
+		{Time: t0, Endpoint: "/infer", Tenant: "chat", Tier: "interactive", Status: 200,
+			Request: json.RawMessage(`{"prompt":"a"}`)},
+		{Time: t0.Add(400 * time.Millisecond), Endpoint: "/infer", Status: 429, Request: json.RawMessage(`{"prompt":"b"}`)},
+	}
+	sum := replay(context.Background(), srv.Client(), options{
+		target:      srv.URL,
+		speed:       2,
+		concurrency: 4,
+		apiKey:      "replay-key",
+		timeout:     time.Second,
+	}, records)
+
+	if sum.sent != 2 || sum.failed != 0 || sum.statuses[200] != 2 || sum.mismatched != 1 {
+		t.Fatalf("summary = %+v", sum)
+	}
+	if gap := arrived[1] - arrived[0]; gap < 150*time.Millisecond || gap > 350*time.Millisecond {
+		t.Fatalf("requests arrived %s apart, want about 200ms at speed 2", gap)
+	}
+	if h := headers[0]; h.Get("X-Tenant") != "chat" || h.Get("X-Priority") != "interactive" ||
+		h.Get("Authorization") != "Bearer replay-key" {
+		t.Fatalf("headers = %v", h)
+	}
+
+	var out strings.Builder
+	sum.print(&out)
+	if !strings.Contains(out.String(), "replayed 2 requests") ||
+		!strings.Contains(out.String(), "1 answered with a different status") {
+		t.Fatalf("summary output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// errQueueFull is returned when every concurrency slot is busy and the
+	// wait queue is already at MAX_QUEUE_DEPTH, or when a queued request is
+	// pushed out by one from a higher priority tier.
+	errQueueFull = errors.New("admission queue is full")
+	// errQueueTimeout is returned when a queued request does not get a slot
+	// within MAX_QUEUE_WAIT.
+	errQueueTimeout = errors.New("timed out waiting for a free slot")
+)
+
+// requestClass is what the admission queue schedules a request by.
+type requestClass struct {
+	// tier indexes the priority tiers, 0 being the highest.
+	tier   int
+	tenant string
+	// weight is the tenant's share within its tier; zero counts as one.
+	weight float64
+}
+
+// admissionQueue bounds how many requests run at once and how many may wait
+// for a slot, so bursts are shed early instead of piling up in goroutines.
+//
+// Queued requests are admitted by policy rather than wake-up order: a
+// higher priority tier always goes first, and within a tier tenants share
+// slots in proportion to their weights (self-clocked fair queueing). When
+// the queue is full, a request may push out the last-in-line request of a
+// lower tier.
+type admissionQueue struct {
+	capacity int
+	maxDepth int
+	maxWait  time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+	tiers    []waiterHeap
+	// vtime is each tier's virtual time: the finish tag of the request
+	// admitted last.
+	vtime []float64
+	// finish is the latest finish tag handed to each tier and tenant.
+	finish map[tenantKey]float64
+	seq    uint64
+}
+
+type tenantKey struct {
+	tier   int
+	tenant string
+}
+
+// waiter is a request queued for a slot.
+type waiter struct {
+	class  requestClass
+	finish float64
+	seq    uint64
+	// index is the waiter's position in its tier's heap, or -1 once it
+	// has left the queue.
+	index int
+	// ready receives nil when the waiter is admitted and errQueueFull if
+	// it is pushed out.
+	ready chan error
+}
+
+func newAdmissionQueue(maxConcurrency, maxDepth int, maxWait time.Duration) *admissionQueue {
+	return &admissionQueue{
+		capacity: maxConcurrency,
+		maxDepth: maxDepth,
+		maxWait:  maxWait,
+		finish:   make(map[tenantKey]float64),
+	}
+}
+
+// Acquire takes a concurrency slot for a request of class c, waiting in the
+// queue for at most maxWait if none is free. The returned func releases the
+// slot.
+func (q *admissionQueue) Acquire(ctx context.Context, c requestClass) (func(), error) {
+	q.mu.Lock()
+	if q.inFlight < q.capacity && q.queued == 0 {
+		q.inFlight++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.queued >= q.maxDepth && !q.evictBelowLocked(c.tier) {
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	w := q.enqueueLocked(c)
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case err = <-w.ready:
+		if err != nil {
+			return nil, err
+		}
+		return q.release, nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if w.index >= 0 {
+		q.removeLocked(w)
+	} else if <-w.ready == nil {
+		// Admitted just as we gave up; pass the slot on.
+		q.inFlight--
+		q.dispatchLocked()
+	}
+	return nil, err
+}
+
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	q.inFlight--
+	q.dispatchLocked()
+	q.mu.Unlock()
+}
+
+// enqueueLocked queues a waiter for c, tagging it with the virtual time at
+// which its tenant's fair share would have served it.
+func (q *admissionQueue) enqueueLocked(c requestClass) *waiter {
+	for len(q.tiers) <= c.tier {
+		q.tiers = append(q.tiers, nil)
+		q.vtime = append(q.vtime, 0)
+	}
+	weight := c.weight
+	if weight <= 0 {
+		weight = 1
+	}
+	key := tenantKey{c.tier, c.tenant}
+	finish := max(q.vtime[c.tier], q.finish[key]) + 1/weight
+	q.finish[key] = finish
+
+	q.seq++
+	w := &waiter{class: c, finish: finish, seq: q.seq, ready: make(chan error, 1)}
+	heap.Push(&q.tiers[c.tier], w)
+	q.queued++
+	return w
+}
+
+// dispatchLocked admits queued requests while slots are free: the lowest
+// finish tag of the highest non-empty tier first.
+func (q *admissionQueue) dispatchLocked() {
+	for q.inFlight < q.capacity && q.queued > 0 {
+		for i := range q.tiers {
+			if len(q.tiers[i]) == 0 {
+				continue
+			}
+			w := heap.Pop(&q.tiers[i]).(*waiter)
+			q.vtime[i] = w.finish
+			q.pruneLocked(i)
+			q.queued--
+			q.inFlight++
+			w.ready <- nil
+			break
+		}
+	}
+	q.resetIfIdleLocked()
+}
+
+// evictBelowLocked pushes out the last-in-line waiter of the lowest tier
+// below tier to make room. It reports whether it found one.
+func (q *admissionQueue) evictBelowLocked(tier int) bool {
+	for i := len(q.tiers) - 1; i > tier; i-- {
+		h := q.tiers[i]
+		if len(h) == 0 {
+			continue
+		}
+		last := h[0]
+		for _, w := range h[1:] {
+			if w.less(last) {
+				continue
+			}
+			last = w
+		}
+		q.removeLocked(last)
+		last.ready <- errQueueFull
+		return true
+	}
+	return false
+}
+
+func (q *admissionQueue) removeLocked(w *waiter) {
+	heap.Remove(&q.tiers[w.class.tier], w.index)
+	q.queued--
+	q.resetIfIdleLocked()
+}
+
+// pruneLocked drops the finish tags of tier's tenants that virtual time has
+// caught up with. They no longer affect scheduling, and under sustained
+// load the queue may never be idle long enough for resetIfIdleLocked, so
+// without pruning every tenant ever seen would stay in the map.
+func (q *admissionQueue) pruneLocked(tier int) {
+	for key, finish := range q.finish {
+		if key.tier == tier && finish <= q.vtime[tier] {
+			delete(q.finish, key)
+		}
+	}
+}
+
+// resetIfIdleLocked forgets the fair-queueing history once nothing is
+// waiting, so it only ever covers tenants with queued requests.
+func (q *admissionQueue) resetIfIdleLocked() {
+	if q.queued > 0 || len(q.finish) == 0 {
+		return
+	}
+	clear(q.finish)
+	clear(q.vtime)
+}
+
+// InFlight returns the number of requests currently holding a slot.
+func (q *admissionQueue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight
+}
+
+// Capacity returns the number of concurrency slots.
+func (q *admissionQueue) Capacity() int {
+	return q.capacity
+}
+
+// Queued returns the number of requests waiting for a slot.
+func (q *admissionQueue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued
+}
+
+// MaxDepth returns the configured queue depth.
+func (q *admissionQueue) MaxDepth() int {
+	return q.maxDepth
+}
+
+// less orders waiters by finish tag, then arrival.
+func (w *waiter) less(o *waiter) bool {
+	if w.finish != o.finish {
+		return w.finish < o.finish
+	}
+	return w.seq < o.seq
+}
+
+// waiterHeap is a min-heap of waiters by finish tag.
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int           { return len(h) }
+func (h waiterHeap) Less(i, j int) bool { return h[i].less(h[j]) }
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
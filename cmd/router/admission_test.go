@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueueShedsWhenFull(t *testing.T) {
+	q := newAdmissionQueue(1, 1, time.Second)
+	ctx := context.Background()
+
+	release, err := q.Acquire(ctx, requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan error, 1)
+	go func() {
+		rel, err := q.Acquire(ctx, requestClass{})
+		if err == nil {
+			rel()
+		}
+		admitted <- err
+	}()
+	waitFor(t, func() bool { return q.Queued() == 1 })
+
+	if _, err := q.Acquire(ctx, requestClass{}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("err = %v, want %v", err, errQueueFull)
+	}
+
+	release()
+	if err := <-admitted; err != nil {
+		t.Fatalf("queued request: %v", err)
+	}
+	if q.Queued() != 0 || q.InFlight() != 0 {
+		t.Fatalf("queued=%d inFlight=%d after release", q.Queued(), q.InFlight())
+	}
+}
+
+func TestAdmissionQueueWaitTimeout(t *testing.T) {
+	q := newAdmissionQueue(1, 4, 20*time.Millisecond)
+	release, err := q.Acquire(context.Background(), requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	if _, err := q.Acquire(context.Background(), requestClass{}); !errors.Is(err, errQueueTimeout) {
+		t.Fatalf("err = %v, want %v", err, errQueueTimeout)
+	}
+	if q.Queued() != 0 {
+		t.Fatalf("queued = %d after timeout", q.Queued())
+	}
+}
+
+// queueWaiter starts acquiring a slot for c in the background and waits
+// until the request is queued. The returned channel yields the result;
+// admitted requests release their slot after recording name in order.
+func queueWaiter(t *testing.T, q *admissionQueue, c requestClass, name string, order chan<- string) <-chan error {
+	t.Helper()
+	n := q.Queued()
+	done := make(chan error, 1)
+	go func() {
+		rel, err := q.Acquire(context.Background(), c)
+		if err == nil {
+			order <- name
+			rel()
+		}
+		done <- err
+	}()
+	waitFor(t, func() bool { return q.Queued() == n+1 })
+	return done
+}
+
+func TestAdmissionQueueAdmitsHigherTierFirst(t *testing.T) {
+	q := newAdmissionQueue(1, 4, time.Second)
+	release, err := q.Acquire(context.Background(), requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 2)
+	low := queueWaiter(t, q, requestClass{tier: 1}, "low", order)
+	high := queueWaiter(t, q, requestClass{tier: 0}, "high", order)
+	release()
+	if err := errors.Join(<-low, <-high); err != nil {
+		t.Fatal(err)
+	}
+	if first := <-order; first != "high" {
+		t.Fatalf("first admitted = %q, want high", first)
+	}
+}
+
+func TestAdmissionQueueSharesByTenantWeight(t *testing.T) {
+	q := newAdmissionQueue(1, 12, time.Second)
+	release, err := q.Acquire(context.Background(), requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 12)
+	var done []<-chan error
+	for range 6 {
+		done = append(done, queueWaiter(t, q, requestClass{tenant: "chat", weight: 2}, "chat", order))
+	}
+	for range 6 {
+		done = append(done, queueWaiter(t, q, requestClass{tenant: "batch", weight: 1}, "batch", order))
+	}
+	release()
+	for _, d := range done {
+		if err := <-d; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+	for range 6 {
+		counts[<-order]++
+	}
+	if counts["chat"] != 4 || counts["batch"] != 2 {
+		t.Fatalf("first six admitted = %v, want chat:4 batch:2", counts)
+	}
+}
+
+func TestAdmissionQueuePushesOutLowerTierWhenFull(t *testing.T) {
+	q := newAdmissionQueue(1, 1, time.Second)
+	release, err := q.Acquire(context.Background(), requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 2)
+	low := queueWaiter(t, q, requestClass{tier: 1}, "low", order)
+	if _, err := q.Acquire(context.Background(), requestClass{tier: 1}); !errors.Is(err, errQueueFull) {
+		t.Fatalf("same-tier err = %v, want %v", err, errQueueFull)
+	}
+
+	high := make(chan error, 1)
+	go func() {
+		rel, err := q.Acquire(context.Background(), requestClass{tier: 0})
+		if err == nil {
+			rel()
+		}
+		high <- err
+	}()
+	if err := <-low; !errors.Is(err, errQueueFull) {
+		t.Fatalf("low-tier err = %v, want %v", err, errQueueFull)
+	}
+	release()
+	if err := <-high; err != nil {
+		t.Fatalf("high-tier request: %v", err)
+	}
+}
+
+func TestAdmissionQueuePrunesFinishTags(t *testing.T) {
+	q := newAdmissionQueue(1, 1000, time.Second)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight = 1
+
+	// A new tenant arrives for every request admitted, and the queue is
+	// never empty, so it never resets.
+	for i := range 1000 {
+		q.enqueueLocked(requestClass{tenant: strconv.Itoa(i)})
+		if q.queued > 1 {
+			q.inFlight--
+			q.dispatchLocked()
+		}
+	}
+	if q.queued == 0 || len(q.finish) > q.queued {
+		t.Fatalf("%d finish tags kept for %d queued requests", len(q.finish), q.queued)
+	}
+}
+
+func TestInferQueueFullReturns429(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	rt := newTestRouter(backend.URL)
+	rt.admission = newAdmissionQueue(1, 0, time.Second)
+
+	go postJSON(t, rt, "/infer", `{"prompt":"slow"}`)
+	waitFor(t, func() bool { return rt.admission.InFlight() == 1 })
+
+	rec := postJSON(t, rt, "/infer", `{"prompt":"hi"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After header")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log: a request the router answered,
+// with enough of it to replay.
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Endpoint  string    `json:"endpoint"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Tier      string    `json:"tier,omitempty"`
+	Backend   string    `json:"backend,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMs float64   `json:"latencyMs"`
+	// Request is the request body, prompt and sampling parameters
+	// included. It is left out if the body was not JSON or was larger
+	// than AUDIT_MAX_BODY_BYTES.
+	Request json.RawMessage `json:"request,omitempty"`
+	// RequestBytes is the size of the request body: its Content-Length,
+	// or as much as the router read of it when that is not known.
+	RequestBytes int64 `json:"requestBytes"`
+	// Response is the response body as sent, server-sent events
+	// included, cut off after AUDIT_MAX_BODY_BYTES.
+	Response          string `json:"response,omitempty"`
+	ResponseBytes     int    `json:"responseBytes"`
+	ResponseTruncated bool   `json:"responseTruncated,omitempty"`
+}
+
+// redaction says which parts of a request and response the audit log
+// replaces with a placeholder.
+type redaction struct {
+	prompts bool
+	outputs bool
+	// hash adds a SHA-256 prefix of the redacted text to the
+	// placeholder, so repeated prompts can still be told apart.
+	hash bool
+}
+
+// parseRedaction reads AUDIT_REDACT style values: any of "prompts" and
+// "outputs".
+func parseRedaction(items []string, hash bool) (redaction, error) {
+	rd := redaction{hash: hash}
+	for _, item := range items {
+		switch item {
+		case "prompts":
+			rd.prompts = true
+		case "outputs":
+			rd.outputs = true
+		default:
+			return redaction{}, fmt.Errorf("unknown redaction %q, want prompts or outputs", item)
+		}
+	}
+	return rd, nil
+}
+
+// placeholder stands in for a redacted string. It keeps the length so a
+// replay can send text of the same size.
+func (rd redaction) placeholder(s string) string {
+	if rd.hash {
+		sum := sha256.Sum256([]byte(s))
+		return fmt.Sprintf("[redacted len=%d sha256=%x]", len(s), sum[:8])
+	}
+	return fmt.Sprintf("[redacted len=%d]", len(s))
+}
+
+// redacts reports whether the string field key of an object found under
+// parent is a prompt or output that should be redacted. This covers the
+// request and response bodies of /infer and the OpenAI-compatible
+// endpoints, streamed chunks included.
+func (rd redaction) redacts(key, parent string) bool {
+	switch {
+	case key == "prompt", key == "content" && parent == "messages":
+		return rd.prompts
+	case key == "output", key == "text", key == "content":
+		return rd.outputs
+	}
+	return false
+}
+
+// redactJSON redacts a decoded JSON value in place. parent is the key the
+// value sits under and grandparent the key above that; arrays hand both on
+// to their elements, and an array of strings is redacted element by element
+// when its key would redact a single string, as in "prompt": ["a", "b"].
+func (rd redaction) redactJSON(v any, parent, grandparent string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			if s, ok := x.(string); ok {
+				if rd.redacts(k, parent) {
+					v[k] = rd.placeholder(s)
+				}
+				continue
+			}
+			v[k] = rd.redactJSON(x, k, parent)
+		}
+	case []any:
+		for i, x := range v {
+			if s, ok := x.(string); ok {
+				if rd.redacts(parent, grandparent) {
+					v[i] = rd.placeholder(s)
+				}
+				continue
+			}
+			v[i] = rd.redactJSON(x, parent, grandparent)
+		}
+	}
+	return v
+}
+
+// redactDocument redacts one JSON document. Anything that does not parse is
+// replaced whole, since there is no telling what it holds.
+func (rd redaction) redactDocument(s string) string {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return rd.placeholder(s)
+	}
+	out, err := json.Marshal(rd.redactJSON(v, "", ""))
+	if err != nil {
+		return rd.placeholder(s)
+	}
+	return string(out)
+}
+
+// redactResponse redacts a response body, event by event if it is a
+// server-sent event stream.
+func (rd redaction) redactResponse(s string) string {
+	if !strings.HasPrefix(s, "data:") {
+		return rd.redactDocument(s)
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		data, ok := strings.CutPrefix(line, "data:")
+		if data = strings.TrimSpace(data); !ok || data == sseDone {
+			continue
+		}
+		lines[i] = "data: " + rd.redactDocument(data)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (rd redaction) apply(rec *auditRecord) {
+	if !rd.prompts && !rd.outputs {
+		return
+	}
+	if rec.Request != nil {
+		rec.Request = json.RawMessage(rd.redactDocument(string(rec.Request)))
+	}
+	if rec.Response != "" {
+		rec.Response = rd.redactResponse(rec.Response)
+	}
+}
+
+// auditLog appends auditRecords as JSON lines to a file, rotating it once
+// it reaches maxBytes. Rotated files are kept as path.1 (the newest) to
+// path.<maxFiles>; older ones are overwritten. A write failure drops the
+// record rather than failing the request.
+type auditLog struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+	// maxBody caps how much of each request and response body is
+	// recorded.
+	maxBody int
+	redact  redaction
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	failing bool
+}
+
+func openAuditLog(path string, maxBytes int64, maxFiles, maxBody int, redact redaction) (*auditLog, error) {
+	l := &auditLog{
+		path:     path,
+		maxBytes: maxBytes,
+		maxFiles: max(1, maxFiles),
+		maxBody:  maxBody,
+		redact:   redact,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+// Write redacts rec and appends it to the log.
+func (l *auditLog) Write(rec auditRecord) {
+	l.redact.apply(&rec)
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("encoding audit record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotateLocked(); err != nil {
+			// Keep appending to the current file rather than
+			// losing records.
+			log.Printf("rotating audit log %s: %v", l.path, err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	switch {
+	case err != nil && !l.failing:
+		log.Printf("writing audit log %s failed, dropping records: %v", l.path, err)
+	case err == nil && l.failing:
+		log.Printf("audit log %s writable again", l.path)
+	}
+	l.failing = err != nil
+}
+
+// rotateLocked shifts path.<i> to path.<i+1>, moves the current file to
+// path.1 and starts a new one.
+func (l *auditLog) rotateLocked() error {
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	old := l.f
+	if err := l.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// Close closes the current file.
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// auditEntry collects what the handlers learn about a request for its
+// audit record.
+type auditEntry struct {
+	tenant, tier, backend string
+}
+
+// auditKey is the context key of a request's *auditEntry.
+type auditKey struct{}
+
+// noteClass records the tenant and priority tier a request was queued
+// under.
+func noteClass(ctx context.Context, tier, tenant string) {
+	if e, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		e.tier, e.tenant = tier, tenant
+	}
+}
+
+// noteBackend records the backend that served a request.
+func noteBackend(ctx context.Context, backend string) {
+	if e, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		e.backend = backend
+	}
+}
+
+// audited writes an audit record for every request h answers when the
+// router has an audit log. It wraps the admission queue so rejected
+// requests are recorded too.
+func (rt *router) audited(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.audit == nil {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		// Only enough of the body to tell whether it fits in the record
+		// is buffered, before the caller is authenticated; the rest
+		// streams through to h.
+		head, err := io.ReadAll(io.LimitReader(r.Body, int64(rt.audit.maxBody)+1))
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		body := &countingBody{Reader: io.MultiReader(bytes.NewReader(head), r.Body), Closer: r.Body}
+		r.Body = body
+		entry := &auditEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), auditKey{}, entry))
+		rec := &auditRecorder{statusRecorder: statusRecorder{ResponseWriter: w}, max: rt.audit.maxBody}
+
+		h(rec, r)
+
+		record := auditRecord{
+			Time:              start.UTC(),
+			Endpoint:          endpoint,
+			Tenant:            entry.tenant,
+			Tier:              entry.tier,
+			Backend:           entry.backend,
+			Status:            rec.Status(),
+			LatencyMs:         float64(time.Since(start).Microseconds()) / 1000,
+			RequestBytes:      max(r.ContentLength, body.n),
+			Response:          rec.body.String(),
+			ResponseBytes:     rec.bytes,
+			ResponseTruncated: rec.bytes > rec.body.Len(),
+		}
+		if len(head) <= rt.audit.maxBody && json.Valid(head) {
+			record.Request = head
+		}
+		rt.audit.Write(record)
+	}
+}
+
+// auditRecorder keeps the first max bytes of a response for the audit log.
+type auditRecorder struct {
+	statusRecorder
+	max   int
+	body  bytes.Buffer
+	bytes int
+}
+
+func (a *auditRecorder) Write(b []byte) (int, error) {
+	a.bytes += len(b)
+	if room := a.max - a.body.Len(); room > 0 {
+		a.body.Write(b[:min(len(b), room)])
+	}
+	return a.statusRecorder.Write(b)
+}
+
+// countingBody is a request body that counts the bytes read from it.
+type countingBody struct {
+	io.Reader
+	io.Closer
+	n int64
+}
+
+func (c *countingBody) Read(b []byte) (int, error) {
+	n, err := c.Reader.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var recs []auditRecord
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("bad audit line %q: %v", sc.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestRouterWritesAuditLog(t *testing.T) {
+	backend := fakeBackend(t)
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	audit, err := openAuditLog(path, 1<<20, 2, 1<<10, redaction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(backend.URL)
+	rt.audit = audit
+	sched, err := newSchedPolicy([]string{"interactive", "batch"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.sched = sched
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/completions",
+		strings.NewReader(`{"prompt": "hello", "max_tokens": 5}`))
+	req.Header.Set(tenantHeader, "chat")
+	req.Header.Set(priorityHeader, "interactive")
+	rt.routes().ServeHTTP(httptest.NewRecorder(), req)
+	if rec := postJSON(t, rt, "/infer", `not json`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+
+	recs := readAuditLog(t, path)
+	if len(recs) != 2 {
+		t.Fatalf("got %d audit records, want 2", len(recs))
+	}
+	got := recs[0]
+	if got.Endpoint != "/v1/completions" || got.Tenant != "chat" || got.Tier != "interactive" ||
+		got.Backend != backend.URL || got.Status != http.StatusOK || got.Time.IsZero() {
+		t.Fatalf("record = %+v", got)
+	}
+	if string(got.Request) != `{"prompt":"hello","max_tokens":5}` || !strings.Contains(got.Response, "HELLO") {
+		t.Fatalf("request = %s, response = %q", got.Request, got.Response)
+	}
+	if bad := recs[1]; bad.Request != nil || bad.RequestBytes != int64(len("not json")) || bad.Tier != "batch" {
+		t.Fatalf("invalid request record = %+v", bad)
+	}
+}
+
+func TestAuditLogBoundsRequestBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	audit, err := openAuditLog(path, 1<<20, 2, 64, redaction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.audit = audit
+
+	body := `{"prompt":"` + strings.Repeat("x", 1000) + `"}`
+	var got []byte
+	h := rt.audited("/infer", func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if got, err = io.ReadAll(r.Body); err != nil {
+			t.Error(err)
+		}
+	})
+	for _, length := range []int64{int64(len(body)), -1} {
+		req := httptest.NewRequest(http.MethodPost, "/infer", strings.NewReader(body))
+		req.ContentLength = length
+		h(httptest.NewRecorder(), req)
+		if string(got) != body {
+			t.Fatalf("handler read %d bytes, want the whole %d byte body", len(got), len(body))
+		}
+	}
+
+	recs := readAuditLog(t, path)
+	if len(recs) != 2 {
+		t.Fatalf("got %d audit records, want 2", len(recs))
+	}
+	for _, rec := range recs {
+		if rec.Request != nil || rec.RequestBytes != int64(len(body)) {
+			t.Fatalf("record = %+v", rec)
+		}
+	}
+}
+
+func TestAuditRedaction(t *testing.T) {
+	rd := redaction{prompts: true, outputs: true}
+	rec := auditRecord{
+		Request:  json.RawMessage(`{"messages":[{"role":"user","content":"secret"}],"temperature":0.5}`),
+		Response: "data: {\"choices\":[{\"delta\":{\"content\":\"answer\"}}]}\n\ndata: [DONE]\n\n",
+	}
+	rd.apply(&rec)
+	if string(rec.Request) != `{"messages":[{"content":"[redacted len=6]","role":"user"}],"temperature":0.5}` {
+		t.Fatalf("request = %s", rec.Request)
+	}
+	if rec.Response != "data: {\"choices\":[{\"delta\":{\"content\":\"[redacted len=6]\"}}]}\n\ndata: [DONE]\n\n" {
+		t.Fatalf("response = %q", rec.Response)
+	}
+
+	// A batch of prompts is redacted element by element.
+	rec = auditRecord{Request: json.RawMessage(`{"prompt":["secret","other secret",[1,2]],"n":2}`)}
+	rd.apply(&rec)
+	if string(rec.Request) != `{"n":2,"prompt":["[redacted len=6]","[redacted len=12]",[1,2]]}` {
+		t.Fatalf("array prompt request = %s", rec.Request)
+	}
+
+	// Prompts only: the output stays, the prompt echoed by /infer goes.
+	rd = redaction{prompts: true, hash: true}
+	got := rd.redactResponse(`{"prompt":"hi","output":"HI"}`)
+	if got != `{"output":"HI","prompt":"[redacted len=2 sha256=8f434346648f6b96]"}` {
+		t.Fatalf("response = %s", got)
+	}
+	if got := rd.redactResponse("upstream said: hi"); got != "[redacted len=17 sha256=1c79bbc9acaf12e1]" {
+		t.Fatalf("non-JSON response = %q", got)
+	}
+
+	if _, err := parseRedaction([]string{"prompts", "headers"}, false); err == nil {
+		t.Fatal("unknown redaction accepted")
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	audit, err := openAuditLog(path, 200, 2, 1<<10, redaction{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		audit.Write(auditRecord{Endpoint: "/infer", Status: http.StatusOK, Response: strings.Repeat("x", 100)})
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		if recs := readAuditLog(t, p); len(recs) != 1 {
+			t.Fatalf("%s holds %d records, want 1", p, len(recs))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("%s.3 exists beyond AUDIT_MAX_FILES: %v", path, err)
+	}
+}
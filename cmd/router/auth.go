@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keyIdentity is who an API key belongs to.
+type keyIdentity struct {
+	tenant string
+	// tier, if set, is the priority tier the key's requests always get.
+	tier string
+}
+
+// apiKeys maps the SHA-256 of each API key to its identity.
+type apiKeys map[[sha256.Size]byte]keyIdentity
+
+// keyStore holds the API keys the router accepts, read from the Secret
+// mounted at AUTH_DIR. Every file in the directory is named after a tenant
+// and lists that tenant's keys, one per line, as the hex SHA-256 of the key
+// (optionally prefixed with "sha256:") followed by an optional priority
+// tier. Blank lines and lines starting with # are ignored.
+//
+// The kubelet updates the mounted files when the Secret changes, and Run
+// picks the change up without a restart.
+type keyStore struct {
+	dir  string
+	keys atomic.Pointer[apiKeys]
+}
+
+// newKeyStore loads the keys in dir. It fails if they cannot be read, so a
+// router never starts open by mistake.
+func newKeyStore(dir string) (*keyStore, error) {
+	s := &keyStore{dir: dir}
+	keys, err := loadAPIKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.keys.Store(&keys)
+	return s, nil
+}
+
+// Run reloads the keys every interval until ctx is done. If a reload fails
+// the previous keys stay in force.
+func (s *keyStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Reload()
+		}
+	}
+}
+
+// Reload rereads the keys from disk.
+func (s *keyStore) Reload() {
+	keys, err := loadAPIKeys(s.dir)
+	if err != nil {
+		log.Printf("reloading API keys failed, keeping the previous ones: %v", err)
+		return
+	}
+	if old := s.keys.Load(); old != nil && maps.Equal(*old, keys) {
+		return
+	}
+	s.keys.Store(&keys)
+	log.Printf("loaded %d API keys", len(keys))
+}
+
+// authenticate returns the identity of the request's bearer token.
+func (s *keyStore) authenticate(r *http.Request) (keyIdentity, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return keyIdentity{}, false
+	}
+	id, ok := (*s.keys.Load())[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	return id, ok
+}
+
+// loadAPIKeys reads every tenant file in dir. Entries starting with a dot
+// are the Secret volume's own bookkeeping and are skipped. A key listed
+// more than once is an error, since it could only belong to one tenant.
+func loadAPIKeys(dir string) (apiKeys, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := apiKeys{}
+	for _, e := range entries {
+		tenant := e.Name()
+		if strings.HasPrefix(tenant, ".") {
+			continue
+		}
+		if err := loadTenantKeys(filepath.Join(dir, tenant), tenant, keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func loadTenantKeys(path, tenant string, keys apiKeys) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return fmt.Errorf("%s:%d: want a key hash and an optional tier", tenant, n)
+		}
+		sum, err := hex.DecodeString(strings.TrimPrefix(fields[0], "sha256:"))
+		if err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("%s:%d: not a hex SHA-256 hash", tenant, n)
+		}
+		if prev, ok := keys[[sha256.Size]byte(sum)]; ok {
+			return fmt.Errorf("%s:%d: key already listed for tenant %q", tenant, n, prev.tenant)
+		}
+		id := keyIdentity{tenant: tenant}
+		if len(fields) == 2 {
+			id.tier = fields[1]
+		}
+		keys[[sha256.Size]byte(sum)] = id
+	}
+	return sc.Err()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeKeys lays dir out like a mounted Secret: each tenant file is a
+// symlink into a hidden data directory.
+func writeKeys(t *testing.T, dir string, tenants map[string]string) {
+	t.Helper()
+	data := filepath.Join(dir, "..data")
+	if err := os.MkdirAll(data, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for tenant, content := range tenants {
+		if err := os.WriteFile(filepath.Join(data, tenant), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(dir, tenant)
+		_ = os.Remove(link)
+		if err := os.Symlink(filepath.Join("..data", tenant), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, map[string]string{
+		"chat":    "# interactive clients\nsha256:" + keyHash("chat-key") + " interactive\n\n",
+		"reports": keyHash("reports-key-1") + "\n" + keyHash("reports-key-2") + "\n",
+	})
+
+	keys, err := loadAPIKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("loaded %d keys, want 3", len(keys))
+	}
+	if id := keys[sha256.Sum256([]byte("chat-key"))]; id != (keyIdentity{tenant: "chat", tier: "interactive"}) {
+		t.Fatalf("chat key identity = %+v", id)
+	}
+	if id := keys[sha256.Sum256([]byte("reports-key-2"))]; id != (keyIdentity{tenant: "reports"}) {
+		t.Fatalf("reports key identity = %+v", id)
+	}
+
+	writeKeys(t, dir, map[string]string{"bad": "not-a-hash\n"})
+	if _, err := loadAPIKeys(dir); err == nil || !strings.Contains(err.Error(), "bad:1") {
+		t.Fatalf("err = %v, want a complaint about bad:1", err)
+	}
+}
+
+func TestLoadAPIKeysRejectsSharedKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, map[string]string{
+		"chat":    keyHash("shared-key") + "\n",
+		"reports": keyHash("reports-key") + "\n" + keyHash("shared-key") + "\n",
+	})
+	_, err := loadAPIKeys(dir)
+	if err == nil || !strings.Contains(err.Error(), `reports:2: key already listed for tenant "chat"`) {
+		t.Fatalf("err = %v, want the shared key rejected", err)
+	}
+
+	// A reload that would share a key keeps the previous keys.
+	dir = t.TempDir()
+	writeKeys(t, dir, map[string]string{"chat": keyHash("shared-key") + "\n"})
+	auth, err := newKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKeys(t, dir, map[string]string{"reports": keyHash("shared-key") + "\n"})
+	auth.Reload()
+	req := httptest.NewRequest(http.MethodPost, "/infer", nil)
+	req.Header.Set("Authorization", "Bearer shared-key")
+	if id, ok := auth.authenticate(req); !ok || id.tenant != "chat" {
+		t.Fatalf("shared key belongs to %+v after a bad reload, want chat", id)
+	}
+}
+
+func TestRouterRequiresAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, map[string]string{"chat": keyHash("chat-key") + "\n"})
+	auth, err := newKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.auth = auth
+
+	post := func(authz string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/completions", strings.NewReader(`{"prompt":"hi"}`))
+		if authz != "" {
+			req.Header.Set("Authorization", authz)
+		}
+		rec := httptest.NewRecorder()
+		rt.routes().ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, authz := range []string{"", "Bearer wrong-key", "Basic chat-key"} {
+		rec := post(authz)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("Authorization %q: status = %d, want 401 with a challenge", authz, rec.Code)
+		}
+	}
+	if rec := post("Bearer chat-key"); rec.Code != http.StatusOK {
+		t.Fatalf("valid key: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	// A rotated Secret takes effect on the next reload.
+	writeKeys(t, dir, map[string]string{"chat": keyHash("new-key") + "\n"})
+	auth.Reload()
+	if rec := post("Bearer chat-key"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: status = %d, want 401", rec.Code)
+	}
+	if rec := post("Bearer new-key"); rec.Code != http.StatusOK {
+		t.Fatalf("new key: status = %d", rec.Code)
+	}
+}
+
+func TestAPIKeyDecidesTenant(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, map[string]string{"chat": keyHash("chat-key") + "\n"})
+	auth, err := newKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.auth = auth
+	rt.limits = &rateLimiter{
+		tenants: map[string]rateLimit{"chat": {rps: 1, burst: 1}},
+		store:   newLocalBuckets(),
+	}
+
+	codes := make([]int, 2)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodPost, "/infer", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set("Authorization", "Bearer chat-key")
+		// Claiming another tenant must not escape chat's limit.
+		req.Header.Set(tenantHeader, "someone-else-"+string(rune('a'+i)))
+		rec := httptest.NewRecorder()
+		rt.routes().ServeHTTP(rec, req)
+		codes[i] = rec.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("status codes = %v, want [200 429]", codes)
+	}
+}
+
+func TestUnauthenticatedClientsCannotDrain(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, map[string]string{"chat": keyHash("chat-key") + "\n"})
+	auth, err := newKeyStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.auth = auth
+	mux := rt.routes()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, "/drain", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s /drain on the public port = %d, want 404", method, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/readyz = %d after /drain attempts, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GenerateRequest is the payload the router sends to a model-server backend.
+type GenerateRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	MaxTokens   int      `json:"maxTokens,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Stream      bool     `json:"stream,omitempty"`
+	// KVEndpoint is the KV cache node that owns this prompt's prefix.
+	KVEndpoint string `json:"kvEndpoint,omitempty"`
+}
+
+// GenerateResponse is what a model-server backend returns for a generation.
+// Token counts and the finish reason are optional; the router estimates or
+// defaults them when a backend leaves them out.
+type GenerateResponse struct {
+	Text             string `json:"text"`
+	PromptTokens     int    `json:"promptTokens,omitempty"`
+	CompletionTokens int    `json:"completionTokens,omitempty"`
+	FinishReason     string `json:"finishReason,omitempty"`
+}
+
+// GenerateChunk is one server-sent event from a streaming backend. The last
+// chunk of a generation carries the finish reason.
+type GenerateChunk struct {
+	Text         string `json:"text"`
+	FinishReason string `json:"finishReason,omitempty"`
+}
+
+// upstreamError carries a non-2xx response from a backend so the router can
+// hand the same status code and body back to its caller.
+type upstreamError struct {
+	backend     string
+	status      int
+	contentType string
+	body        []byte
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("backend %s returned %d: %s", e.backend, e.status, strings.TrimSpace(string(e.body)))
+}
+
+var errNoBackends = errors.New("no backends configured")
+
+// backendPool forwards generation requests to a set of model-server
+// backends. Requests whose prompts share a prefix are sent to the same
+// backend through a consistent-hash ring so its prefix KV cache gets reused;
+// if that backend is running well above the pool's average load, or the
+// prompt is too short to have a cacheable prefix, the least-loaded backend
+// is used instead. A backend that cannot be reached is skipped in favour of
+// the next one.
+type backendPool struct {
+	endpoints []string
+	client    *http.Client
+	ring      *hashRing
+	prefix    prefixHasher
+	// loadFactor is how far above the average in-flight load the preferred
+	// backend may run before requests spill over to the least-loaded one.
+	loadFactor float64
+
+	mu   sync.Mutex
+	load map[string]int
+	next int
+
+	// onError, if set, is told about every failed call to a backend.
+	onError func(backend, reason string)
+}
+
+func newBackendPool(endpoints []string, client *http.Client) *backendPool {
+	return &backendPool{
+		endpoints:  endpoints,
+		client:     client,
+		ring:       newHashRing(endpoints),
+		prefix:     prefixHasher{blockTokens: 16, maxBlocks: 4},
+		loadFactor: 1.25,
+		load:       make(map[string]int, len(endpoints)),
+	}
+}
+
+// candidates returns the backends to try for prompt, best first.
+func (p *backendPool) candidates(prompt string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.prefix.Key(prompt)
+	if !ok {
+		return p.byLoadLocked()
+	}
+	order := p.ring.Lookup(key)
+	if p.overloadedLocked(order[0]) {
+		least := p.byLoadLocked()[0]
+		order = append([]string{least}, slices.DeleteFunc(order, func(b string) bool { return b == least })...)
+	}
+	return order
+}
+
+// overloadedLocked reports whether one more request would push backend
+// above loadFactor times the pool's average load.
+func (p *backendPool) overloadedLocked(backend string) bool {
+	total := 1
+	for _, n := range p.load {
+		total += n
+	}
+	limit := math.Ceil(float64(total) / float64(len(p.endpoints)) * p.loadFactor)
+	return float64(p.load[backend]+1) > limit
+}
+
+// byLoadLocked returns the backends ordered by in-flight load. Ties are
+// broken round-robin so idle backends share the traffic.
+func (p *backendPool) byLoadLocked() []string {
+	n := len(p.endpoints)
+	out := make([]string, 0, n)
+	for i := range p.endpoints {
+		out = append(out, p.endpoints[(p.next+i)%n])
+	}
+	p.next = (p.next + 1) % n
+	slices.SortStableFunc(out, func(a, b string) int { return p.load[a] - p.load[b] })
+	return out
+}
+
+// acquire adds n requests to backend's in-flight load.
+func (p *backendPool) acquire(backend string, n int) {
+	p.mu.Lock()
+	p.load[backend] += n
+	p.mu.Unlock()
+}
+
+func (p *backendPool) release(backend string, n int) {
+	p.mu.Lock()
+	p.load[backend] -= n
+	p.mu.Unlock()
+}
+
+// Generate sends req to a backend and returns its response along with the
+// backend that served it. Upstream status codes are surfaced as
+// *upstreamError and are not retried; transport errors move on to the next
+// backend until every backend has been tried once.
+func (p *backendPool) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, string, error) {
+	req.Stream = false
+	var out GenerateResponse
+	backend, err := p.do(ctx, req, func(backend string, body io.Reader) error {
+		if err := json.NewDecoder(body).Decode(&out); err != nil {
+			return fmt.Errorf("decoding response from backend %s: %w", backend, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, backend, err
+	}
+	return &out, backend, nil
+}
+
+// GenerateStream asks a backend to stream its generation and calls onChunk
+// for every chunk as it arrives. Backend selection and failover work as in
+// Generate, but only until a backend has accepted the request; an error
+// returned by onChunk aborts the stream.
+func (p *backendPool) GenerateStream(ctx context.Context, req GenerateRequest,
+	onChunk func(GenerateChunk) error) (string, error) {
+	req.Stream = true
+	return p.do(ctx, req, func(backend string, body io.Reader) error {
+		return readSSE(body, func(data []byte) error {
+			var chunk GenerateChunk
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return fmt.Errorf("decoding stream chunk from backend %s: %w", backend, err)
+			}
+			return onChunk(chunk)
+		})
+	})
+}
+
+// GenerateBatch sends reqs to a backend's /generate/batch endpoint in one
+// call, trying candidates in order as Generate does, and returns one result
+// per request.
+func (p *backendPool) GenerateBatch(ctx context.Context, candidates []string, model string,
+	reqs []GenerateRequest) ([]BatchGenerateResult, string, error) {
+	if len(p.endpoints) == 0 {
+		return nil, "", errNoBackends
+	}
+	body, err := json.Marshal(BatchGenerateRequest{Model: model, Requests: reqs})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var out BatchGenerateResponse
+	decode := func(backend string, body io.Reader) error {
+		if err := json.NewDecoder(body).Decode(&out); err != nil {
+			return fmt.Errorf("decoding batch response from backend %s: %w", backend, err)
+		}
+		if len(out.Responses) != len(reqs) {
+			return fmt.Errorf("backend %s answered %d of %d batched requests", backend, len(out.Responses), len(reqs))
+		}
+		return nil
+	}
+	backend, err := p.send(ctx, candidates, "/generate/batch", len(reqs), body, decode)
+	if err != nil {
+		return nil, backend, err
+	}
+	return out.Responses, backend, nil
+}
+
+// do posts req to the best candidate backend and hands the successful
+// response body to handle.
+func (p *backendPool) do(ctx context.Context, req GenerateRequest,
+	handle func(backend string, body io.Reader) error) (string, error) {
+	if len(p.endpoints) == 0 {
+		return "", errNoBackends
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	return p.send(ctx, p.candidates(req.Prompt), "/generate", 1, body, handle)
+}
+
+// send posts body to path on each of candidates in turn until one accepts
+// it, counting n requests against that backend's load while the call runs.
+func (p *backendPool) send(ctx context.Context, candidates []string, path string, n int, body []byte,
+	handle func(backend string, body io.Reader) error) (string, error) {
+	var lastErr error
+	for _, backend := range candidates {
+		p.acquire(backend, n)
+		httpResp, err := p.post(ctx, backend, path, body)
+		if err == nil {
+			err = handle(backend, httpResp.Body)
+			_ = httpResp.Body.Close()
+			p.release(backend, n)
+			if err != nil && ctx.Err() == nil {
+				p.reportError(backend, "bad_response")
+			}
+			return backend, err
+		}
+		p.release(backend, n)
+		var upErr *upstreamError
+		switch {
+		case errors.As(err, &upErr):
+			p.reportError(backend, "http_"+strconv.Itoa(upErr.status))
+			return backend, err
+		case errors.Is(context.Cause(ctx), context.DeadlineExceeded):
+			p.reportError(backend, "timeout")
+			return backend, err
+		case ctx.Err() != nil:
+			return backend, err
+		}
+		p.reportError(backend, "unreachable")
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func (p *backendPool) reportError(backend, reason string) {
+	if p.onError != nil {
+		p.onError(backend, reason)
+	}
+}
+
+// post sends body to path on backend. Non-2xx responses are drained and
+// returned as *upstreamError.
+func (p *backendPool) post(ctx context.Context, backend, path string, body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, backend+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpResp.StatusCode < 200 || httpResp.StatusCode > 299 {
+		defer func() { _ = httpResp.Body.Close() }()
+		b, _ := io.ReadAll(io.LimitReader(httpResp.Body, 64<<10))
+		return nil, &upstreamError{
+			backend:     backend,
+			status:      httpResp.StatusCode,
+			contentType: httpResp.Header.Get("Content-Type"),
+			body:        b,
+		}
+	}
+	return httpResp, nil
+}
+
+// backendErrorStatus maps an error from the backend pool onto the HTTP
+// status and message the router reports to its caller. A zero status means
+// the client has gone away and nothing should be written.
+func backendErrorStatus(err error) (int, string) {
+	var upErr *upstreamError
+	switch {
+	case errors.As(err, &upErr):
+		return upErr.status, strings.TrimSpace(string(upErr.body))
+	case errors.Is(err, errNoBackends):
+		return http.StatusServiceUnavailable, err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "backend timed out"
+	case errors.Is(err, context.Canceled):
+		return 0, ""
+	default:
+		return http.StatusBadGateway, "backend unavailable: " + err.Error()
+	}
+}
+
+// writeBackendError reports an error from the backend pool to the caller,
+// passing upstream responses through unchanged.
+func writeBackendError(w http.ResponseWriter, err error) {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		if upErr.contentType != "" {
+			w.Header().Set("Content-Type", upErr.contentType)
+		}
+		w.WriteHeader(upErr.status)
+		_, _ = w.Write(upErr.body)
+		return
+	}
+	if status, msg := backendErrorStatus(err); status != 0 {
+		http.Error(w, msg, status)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BatchGenerateRequest is the payload of a backend's /generate/batch
+// endpoint: several generations the backend may run together.
+type BatchGenerateRequest struct {
+	Model    string            `json:"model"`
+	Requests []GenerateRequest `json:"requests"`
+}
+
+// BatchGenerateResponse answers a BatchGenerateRequest with one result per
+// request, in request order.
+type BatchGenerateResponse struct {
+	Responses []BatchGenerateResult `json:"responses"`
+}
+
+// BatchGenerateResult is the outcome of one request in a batch. A failed
+// request sets Error and, optionally, the HTTP status it would have been
+// answered with on its own.
+type BatchGenerateResult struct {
+	GenerateResponse
+	Error  string `json:"error,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// batcher collects non-streaming generations headed for the same backend
+// for up to maxWait, or until maxSize of them are waiting, and sends them
+// as one /generate/batch call. Each caller waits for its own result.
+type batcher struct {
+	pool    *backendPool
+	model   string
+	maxSize int
+	maxWait time.Duration
+	// timeout bounds each upstream batch call.
+	timeout time.Duration
+
+	// onFlush, if set, is told the size of every batch sent.
+	onFlush func(size int)
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+// pendingBatch is a batch still collecting requests.
+type pendingBatch struct {
+	// candidates are the backends to try, best first. Nil means the batch
+	// holds prompts without a cacheable prefix and goes to the least-loaded
+	// backend.
+	candidates []string
+	items      []*batchItem
+	timer      *time.Timer
+}
+
+type batchItem struct {
+	ctx  context.Context
+	req  GenerateRequest
+	done chan batchResult
+}
+
+type batchResult struct {
+	resp    *GenerateResponse
+	backend string
+	err     error
+}
+
+func newBatcher(pool *backendPool, model string, maxSize int, maxWait, timeout time.Duration) *batcher {
+	return &batcher{
+		pool:    pool,
+		model:   model,
+		maxSize: maxSize,
+		maxWait: maxWait,
+		timeout: timeout,
+		pending: make(map[string]*pendingBatch),
+	}
+}
+
+// Generate queues req for the next batch to its preferred backend and waits
+// for its result or for ctx to end.
+func (b *batcher) Generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, string, error) {
+	if len(b.pool.endpoints) == 0 {
+		return nil, "", errNoBackends
+	}
+	req.Stream = false
+	item := &batchItem{ctx: ctx, req: req, done: make(chan batchResult, 1)}
+	b.add(item)
+
+	select {
+	case res := <-item.done:
+		return res.resp, res.backend, res.err
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+}
+
+// add puts item in the pending batch for its backend, starting one if
+// needed, and sends the batch once it is full.
+func (b *batcher) add(item *batchItem) {
+	// Prompts sharing a prefix stay on their backend so its prefix cache
+	// is reused; the rest are pooled so they batch together.
+	key := ""
+	var candidates []string
+	if _, ok := b.pool.prefix.Key(item.req.Prompt); ok {
+		candidates = b.pool.candidates(item.req.Prompt)
+		key = candidates[0]
+	}
+
+	b.mu.Lock()
+	pb := b.pending[key]
+	if pb == nil {
+		pb = &pendingBatch{candidates: candidates}
+		b.pending[key] = pb
+		pb.timer = time.AfterFunc(b.maxWait, func() { b.flush(key, pb) })
+	}
+	pb.items = append(pb.items, item)
+	full := len(pb.items) >= b.maxSize
+	if full {
+		delete(b.pending, key)
+		pb.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	if full {
+		go b.send(pb)
+	}
+}
+
+// flush sends pb when its wait is over, unless it already went out full.
+func (b *batcher) flush(key string, pb *pendingBatch) {
+	b.mu.Lock()
+	if b.pending[key] != pb {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	b.send(pb)
+}
+
+// send makes the upstream call for pb and hands every waiting caller its
+// result. Callers that gave up while the batch was collecting are dropped.
+func (b *batcher) send(pb *pendingBatch) {
+	items := slices.DeleteFunc(pb.items, func(it *batchItem) bool { return it.ctx.Err() != nil })
+	if len(items) == 0 {
+		return
+	}
+	if b.onFlush != nil {
+		b.onFlush(len(items))
+	}
+
+	// A lone request needs no batching; send it as usual.
+	if len(items) == 1 {
+		resp, backend, err := b.pool.Generate(items[0].ctx, items[0].req)
+		items[0].done <- batchResult{resp: resp, backend: backend, err: err}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+
+	candidates := pb.candidates
+	if candidates == nil {
+		candidates = b.pool.candidates("")
+	}
+	reqs := make([]GenerateRequest, len(items))
+	for i, it := range items {
+		reqs[i] = it.req
+	}
+	results, backend, err := b.pool.GenerateBatch(ctx, candidates, b.model, reqs)
+
+	for i, it := range items {
+		res := batchResult{backend: backend, err: err}
+		switch {
+		case err != nil:
+		case results[i].Error != "":
+			res.err = &upstreamError{
+				backend:     backend,
+				status:      cmp.Or(results[i].Status, http.StatusBadGateway),
+				contentType: "text/plain; charset=utf-8",
+				body:        []byte(results[i].Error),
+			}
+		default:
+			res.resp = &results[i].GenerateResponse
+		}
+		it.done <- res
+	}
+}
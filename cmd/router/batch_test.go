@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBatchBackend upper-cases prompts on /generate and /generate/batch and
+// records the size of every batch it receives. A prompt of "fail" makes its
+// batch entry fail.
+func fakeBatchBackend(t *testing.T) (*httptest.Server, func() []int) {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		sizes []int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate", func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		sizes = append(sizes, 1)
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(GenerateResponse{Text: strings.ToUpper(req.Prompt)})
+	})
+	mux.HandleFunc("/generate/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req BatchGenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sizes = append(sizes, len(req.Requests))
+		mu.Unlock()
+		var resp BatchGenerateResponse
+		for _, g := range req.Requests {
+			res := BatchGenerateResult{GenerateResponse: GenerateResponse{Text: strings.ToUpper(g.Prompt)}}
+			if g.Prompt == "fail" {
+				res = BatchGenerateResult{Error: "prompt rejected", Status: http.StatusUnprocessableEntity}
+			}
+			resp.Responses = append(resp.Responses, res)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), sizes...)
+	}
+}
+
+// generateAll runs prompts through b concurrently and returns the outputs
+// and errors in prompt order.
+func generateAll(b *batcher, prompts []string) ([]string, []error) {
+	outs := make([]string, len(prompts))
+	errs := make([]error, len(prompts))
+	var wg sync.WaitGroup
+	for i, p := range prompts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _, err := b.Generate(context.Background(), GenerateRequest{Prompt: p})
+			errs[i] = err
+			if resp != nil {
+				outs[i] = resp.Text
+			}
+		}()
+	}
+	wg.Wait()
+	return outs, errs
+}
+
+func TestBatcherSendsFullBatchAtOnce(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	b := newBatcher(newBackendPool([]string{backend.URL}, &http.Client{}), "m", 4, time.Minute, time.Second)
+
+	outs, errs := generateAll(b, []string{"a", "b", "c", "d"})
+	for i, want := range []string{"A", "B", "C", "D"} {
+		if errs[i] != nil || outs[i] != want {
+			t.Fatalf("result %d = %q, %v; want %q", i, outs[i], errs[i], want)
+		}
+	}
+	if got := sizes(); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("backend saw batches %v, want [4]", got)
+	}
+}
+
+func TestBatcherFlushesAfterMaxWait(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	b := newBatcher(newBackendPool([]string{backend.URL}, &http.Client{}), "m", 8, 20*time.Millisecond, time.Second)
+
+	start := time.Now()
+	outs, errs := generateAll(b, []string{"x", "y"})
+	if errs[0] != nil || errs[1] != nil || outs[0] != "X" || outs[1] != "Y" {
+		t.Fatalf("results = %q, %v", outs, errs)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("batch sent after %s, before maxWait", elapsed)
+	}
+	if got := sizes(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("backend saw batches %v, want [2]", got)
+	}
+}
+
+func TestBatcherSendsLoneRequestUnbatched(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	b := newBatcher(newBackendPool([]string{backend.URL}, &http.Client{}), "m", 8, time.Millisecond, time.Second)
+
+	resp, _, err := b.Generate(context.Background(), GenerateRequest{Prompt: "solo"})
+	if err != nil || resp.Text != "SOLO" {
+		t.Fatalf("Generate = %+v, %v", resp, err)
+	}
+	if got := sizes(); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("backend saw batches %v, want [1]", got)
+	}
+}
+
+func TestBatcherReportsPerRequestErrors(t *testing.T) {
+	backend, _ := fakeBatchBackend(t)
+	b := newBatcher(newBackendPool([]string{backend.URL}, &http.Client{}), "m", 2, time.Minute, time.Second)
+
+	outs, errs := generateAll(b, []string{"ok", "fail"})
+	if errs[0] != nil || outs[0] != "OK" {
+		t.Fatalf("first result = %q, %v", outs[0], errs[0])
+	}
+	var upErr *upstreamError
+	if !errors.As(errs[1], &upErr) || upErr.status != http.StatusUnprocessableEntity {
+		t.Fatalf("second error = %v, want upstream 422", errs[1])
+	}
+}
+
+func TestBatcherCallerGivesUp(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	b := newBatcher(newBackendPool([]string{backend.URL}, &http.Client{}), "m", 8, 50*time.Millisecond, time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, _, err := b.Generate(ctx, GenerateRequest{Prompt: "late"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := sizes(); len(got) != 0 {
+		t.Fatalf("backend saw batches %v for an abandoned request", got)
+	}
+}
+
+func TestRouterBatchesCompletions(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	rt := newTestRouter(backend.URL)
+	rt.batcher = newBatcher(rt.backends, rt.modelRef, 2, time.Minute, time.Second)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postJSON(t, rt, "/infer", `{"prompt":"hi"}`).Code
+		}()
+	}
+	wg.Wait()
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Fatalf("status codes = %v", codes)
+	}
+	if got := sizes(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("backend saw batches %v, want [2]", got)
+	}
+}
+
+func TestRouterFillsBatchCappedAtConcurrency(t *testing.T) {
+	backend, sizes := fakeBatchBackend(t)
+	// The CRD defaults: maxConcurrency 4, maxBatchSize 8.
+	rt := newTestRouter(backend.URL)
+	rt.enableBatching(8, 5*time.Second)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	codes := make([]int, 4)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postJSON(t, rt, "/infer", `{"prompt":"hi"}`).Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d status = %d", i, code)
+		}
+	}
+	if got := sizes(); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("backend saw batches %v, want [4]", got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("batch sent after %s, want it sent once full", elapsed)
+	}
+}
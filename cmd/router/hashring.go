@@ -0,0 +1,88 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is how many virtual nodes each member gets on the ring, which
+// evens out the share of keys each member owns.
+const ringReplicas = 128
+
+// hashRing is a consistent-hash ring. Adding or removing a member only moves
+// the keys that member owns, so prefix affinity survives scaling.
+type hashRing struct {
+	points  []uint64
+	members map[uint64]string
+	size    int
+}
+
+func newHashRing(members []string) *hashRing {
+	r := &hashRing{members: make(map[uint64]string, len(members)*ringReplicas)}
+	seen := map[string]bool{}
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.size++
+		for i := 0; i < ringReplicas; i++ {
+			h := hashString(m + "#" + strconv.Itoa(i))
+			if _, taken := r.members[h]; taken {
+				continue
+			}
+			r.members[h] = m
+			r.points = append(r.points, h)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Lookup returns every member in preference order for key: the owner first,
+// then the members met walking clockwise around the ring.
+func (r *hashRing) Lookup(key uint64) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= key })
+
+	out := make([]string, 0, r.size)
+	seen := make(map[string]bool, r.size)
+	for i := 0; i < len(r.points) && len(out) < r.size; i++ {
+		m := r.members[r.points[(start+i)%len(r.points)]]
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// prefixHasher derives a routing key from the start of a prompt at
+// token-block granularity, mirroring how paged KV caches share whole blocks:
+// prompts that agree on their first maxBlocks blocks get the same key.
+type prefixHasher struct {
+	blockTokens int
+	maxBlocks   int
+}
+
+// Key returns the prefix key for prompt, or false when the prompt does not
+// fill a single block and so has nothing worth caching.
+func (p prefixHasher) Key(prompt string) (uint64, bool) {
+	// Blocks are sized with the same four-bytes-per-token estimate used for
+	// usage accounting, so no tokenizer is needed.
+	blockBytes := p.blockTokens * 4
+	if blockBytes <= 0 || p.maxBlocks <= 0 || len(prompt) < blockBytes {
+		return 0, false
+	}
+	blocks := min(len(prompt)/blockBytes, p.maxBlocks)
+	return hashString(prompt[:blocks*blockBytes]), true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHashRingIsStableUnderScaleUp(t *testing.T) {
+	before := newHashRing([]string{"a", "b", "c"})
+	after := newHashRing([]string{"a", "b", "c", "d"})
+
+	moved := 0
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		key := hashString(fmt.Sprintf("prompt-%d", i))
+		was, now := before.Lookup(key)[0], after.Lookup(key)[0]
+		if was != now {
+			if now != "d" {
+				t.Fatalf("key %d moved from %s to %s; only moves to the new member are expected", i, was, now)
+			}
+			moved++
+		}
+	}
+	// Roughly a quarter of the keys should move to the new member.
+	if moved < keys/8 || moved > keys/2 {
+		t.Fatalf("%d of %d keys moved", moved, keys)
+	}
+}
+
+func TestHashRingLookupListsEveryMemberOnce(t *testing.T) {
+	r := newHashRing([]string{"a", "b", "b", "c"})
+	got := r.Lookup(hashString("x"))
+	if len(got) != 3 {
+		t.Fatalf("Lookup = %v", got)
+	}
+	seen := map[string]bool{}
+	for _, m := range got {
+		if seen[m] {
+			t.Fatalf("Lookup = %v lists %s twice", got, m)
+		}
+		seen[m] = true
+	}
+}
+
+func TestPrefixHasherBlocks(t *testing.T) {
+	p := prefixHasher{blockTokens: 2, maxBlocks: 2} // 8-byte blocks, 16-byte keys
+
+	if _, ok := p.Key("short"); ok {
+		t.Fatal("a prompt shorter than one block should have no key")
+	}
+	a, _ := p.Key("0123456789abcdef and then something")
+	b, _ := p.Key("0123456789abcdef but something else")
+	if a != b {
+		t.Fatal("prompts sharing the first maxBlocks blocks should share a key")
+	}
+	c, _ := p.Key("0123456789abcdeX and then something")
+	if a == c {
+		t.Fatal("prompts differing inside the first maxBlocks blocks should not share a key")
+	}
+}
+
+// namedBackend answers with its own name so tests can see who served them.
+func namedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(GenerateResponse{Text: name})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPrefixAffinityAndOverloadFallback(t *testing.T) {
+	a := namedBackend(t, "a")
+	b := namedBackend(t, "b")
+
+	rt := newTestRouter(a.URL, b.URL)
+	prompt := strings.Repeat("shared system prompt ", 10)
+
+	var owner string
+	for i := 0; i < 5; i++ {
+		rec := postJSON(t, rt, "/infer", fmt.Sprintf(`{"prompt":%q}`, prompt+fmt.Sprint(i)))
+		var resp InferResponse
+		_ = json.NewDecoder(rec.Body).Decode(&resp)
+		if owner == "" {
+			owner = resp.Backend
+		}
+		if resp.Backend != owner {
+			t.Fatalf("request %d went to %s, want prefix owner %s", i, resp.Backend, owner)
+		}
+	}
+
+	// Pile enough in-flight load on the owner that it counts as overloaded.
+	rt.backends.mu.Lock()
+	rt.backends.load[owner] = 3
+	rt.backends.mu.Unlock()
+
+	rec := postJSON(t, rt, "/infer", fmt.Sprintf(`{"prompt":%q}`, prompt))
+	var resp InferResponse
+	_ = json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Backend == owner {
+		t.Fatalf("overloaded owner %s still received the request", owner)
+	}
+}
+
+func TestKVEndpointFollowsPrefix(t *testing.T) {
+	rt := newTestRouter()
+	rt.kv.Store(newKVState([]string{"cache-0:6379", "cache-1:6379", "cache-2:6379"}))
+	prompt := strings.Repeat("x", 400)
+
+	first := rt.kvEndpointFor(prompt + " one")
+	if first == "" {
+		t.Fatal("expected a KV endpoint for a long prompt")
+	}
+	if again := rt.kvEndpointFor(prompt + " two"); again != first {
+		t.Fatalf("shared prefix mapped to %s and %s", first, again)
+	}
+	if got := rt.kvEndpointFor("hi"); got != "" {
+		t.Fatalf("short prompt mapped to %s", got)
+	}
+}
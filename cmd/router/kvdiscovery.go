@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// hostResolver is the subset of *net.Resolver used for KV discovery.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// kvState is the set of KV cache nodes the router currently routes over.
+type kvState struct {
+	endpoints []string
+	ring      *hashRing
+}
+
+func newKVState(endpoints []string) *kvState {
+	return &kvState{endpoints: endpoints, ring: newHashRing(endpoints)}
+}
+
+// kvDiscovery expands the configured KV_ENDPOINTS into one endpoint per cache
+// node and keeps that list fresh. A KVCachePool is exposed through a headless
+// Service, so its DNS name resolves to one A record per ready pod; each
+// address is mapped back to the pod's own DNS name where one exists
+// (StatefulSet pods have stable ones), so ring positions survive pod
+// restarts that change IPs.
+type kvDiscovery struct {
+	seeds    []string
+	resolver hostResolver
+	interval time.Duration
+	onChange func([]string)
+
+	current []string
+	// last remembers each seed's most recent successful resolution so a
+	// transient DNS failure does not drop its nodes from the ring.
+	last map[string][]string
+}
+
+func newKVDiscovery(seeds []string, resolver hostResolver, interval time.Duration,
+	onChange func([]string)) *kvDiscovery {
+	return &kvDiscovery{
+		seeds:    seeds,
+		resolver: resolver,
+		interval: interval,
+		onChange: onChange,
+		last:     map[string][]string{},
+	}
+}
+
+// Run refreshes the endpoint list every interval until ctx is done.
+func (d *kvDiscovery) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh resolves every seed once and reports the result through onChange
+// if the set of endpoints changed.
+func (d *kvDiscovery) Refresh(ctx context.Context) {
+	var endpoints []string
+	for _, seed := range d.seeds {
+		resolved, err := d.resolve(ctx, seed)
+		if err != nil {
+			log.Printf("kv discovery: resolving %q: %v", seed, err)
+			resolved = d.last[seed]
+			if resolved == nil {
+				resolved = []string{seed}
+			}
+		} else {
+			d.last[seed] = resolved
+		}
+		endpoints = append(endpoints, resolved...)
+	}
+	slices.Sort(endpoints)
+	endpoints = slices.Compact(endpoints)
+
+	if slices.Equal(endpoints, d.current) {
+		return
+	}
+	log.Printf("kv discovery: endpoints changed from %v to %v", d.current, endpoints)
+	d.current = endpoints
+	d.onChange(endpoints)
+}
+
+func (d *kvDiscovery) resolve(ctx context.Context, seed string) ([]string, error) {
+	host, port, err := net.SplitHostPort(seed)
+	if err != nil {
+		host, port = seed, ""
+	}
+	if net.ParseIP(host) != nil {
+		return []string{seed}, nil
+	}
+
+	addrs, err := d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := "." + strings.TrimSuffix(host, ".") + "."
+	out := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		name := addr
+		if names, err := d.resolver.LookupAddr(ctx, addr); err == nil {
+			for _, n := range names {
+				if strings.HasSuffix(n, suffix) {
+					name = strings.TrimSuffix(n, ".")
+					break
+				}
+			}
+		}
+		if port != "" {
+			name = net.JoinHostPort(name, port)
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
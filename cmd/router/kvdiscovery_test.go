@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fakeResolver serves A and PTR records from maps; a missing host is an
+// error.
+type fakeResolver struct {
+	hosts map[string][]string
+	ptrs  map[string][]string
+}
+
+func (f *fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	addrs, ok := f.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	names, ok := f.ptrs[addr]
+	if !ok {
+		return nil, errors.New("no PTR record")
+	}
+	return names, nil
+}
+
+func TestKVDiscoveryTracksPods(t *testing.T) {
+	const svc = "pool-cache.default.svc.cluster.local"
+	res := &fakeResolver{
+		hosts: map[string][]string{svc: {"10.0.0.2", "10.0.0.1"}},
+		ptrs: map[string][]string{
+			"10.0.0.1": {"pool-cache-0." + svc + "."},
+			// Deployment-style pods have no name under the Service.
+			"10.0.0.2": {"10-0-0-2.default.pod.cluster.local."},
+		},
+	}
+
+	var updates [][]string
+	d := newKVDiscovery([]string{svc + ":6379", "10.9.9.9:6379"}, res, 0, func(e []string) {
+		updates = append(updates, e)
+	})
+
+	d.Refresh(context.Background())
+	want := []string{"10.0.0.2:6379", "10.9.9.9:6379", "pool-cache-0." + svc + ":6379"}
+	if len(updates) != 1 || !reflect.DeepEqual(updates[0], want) {
+		t.Fatalf("updates = %v, want [%v]", updates, want)
+	}
+
+	// Nothing changed: no update.
+	d.Refresh(context.Background())
+	if len(updates) != 1 {
+		t.Fatalf("got %d updates for an unchanged set", len(updates))
+	}
+
+	// Scale up.
+	res.hosts[svc] = append(res.hosts[svc], "10.0.0.3")
+	d.Refresh(context.Background())
+	if len(updates) != 2 || len(updates[1]) != 4 {
+		t.Fatalf("updates after scale-up = %v", updates)
+	}
+
+	// A DNS outage keeps the last known nodes.
+	delete(res.hosts, svc)
+	d.Refresh(context.Background())
+	if len(updates) != 2 {
+		t.Fatalf("DNS failure changed the endpoint set: %v", updates[len(updates)-1])
+	}
+}
+
+func TestKVDiscoveryUnresolvedSeedIsKept(t *testing.T) {
+	var got []string
+	d := newKVDiscovery([]string{"unknown:6379"}, &fakeResolver{}, 0, func(e []string) { got = e })
+	d.Refresh(context.Background())
+	if !reflect.DeepEqual(got, []string{"unknown:6379"}) {
+		t.Fatalf("endpoints = %v", got)
+	}
+}
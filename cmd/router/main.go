@@ -1,29 +1,82 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type InferRequest struct {
 	Prompt string `json:"prompt"`
+	Stream bool   `json:"stream,omitempty"`
 }
 
 type InferResponse struct {
 	ModelRef     string   `json:"modelRef"`
 	Prompt       string   `json:"prompt"`
+	Output       string   `json:"output"`
+	Backend      string   `json:"backend"`
 	RouterPod    string   `json:"routerPod"`
+	KVEndpoint   string   `json:"kvEndpoint,omitempty"`
 	KVEndpoints  []string `json:"kvEndpoints"`
 	ProcessingMs int64    `json:"processingMs"`
 }
 
+// router holds the configuration and shared state behind the HTTP handlers.
+type router struct {
+	modelRef       string
+	routerPod      string
+	kv             atomic.Pointer[kvState]
+	backends       *backendPool
+	backendTimeout time.Duration
+	// batcher, if set, groups non-streaming generations into batch calls.
+	batcher *batcher
+
+	admission *admissionQueue
+	sched     schedPolicy
+	// auth, if set, holds the API keys callers must present.
+	auth *keyStore
+	// limits, if set, enforces per-tenant rate limits.
+	limits *rateLimiter
+	// audit, if set, records every request and response.
+	audit   *auditLog
+	metrics *routerMetrics
+	wg      sync.WaitGroup
+
+	drainPeriod time.Duration
+	draining    atomic.Bool
+	drainOnce   sync.Once
+}
+
+// defaultAdminAddr is where the router serves endpoints meant only for
+// its own pod. Binding to loopback keeps them off the pod IP, so neither
+// the Service nor other pods can reach them.
+const defaultAdminAddr = "127.0.0.1:5679"
+
 func main() {
+	adminAddr := getenv("ADMIN_ADDR", defaultAdminAddr)
+	// The pod's preStop hook runs "router drain" in the container.
+	if len(os.Args) > 1 && os.Args[1] == "drain" {
+		if err := requestDrain(adminAddr); err != nil {
+			log.Fatalf("drain failed: %v", err)
+		}
+		return
+	}
+
 	modelRef := getenv("MODEL_REF", "unknown-model")
 	maxConcStr := getenv("MAX_CONCURRENCY", "4")
 	maxConc, err := strconv.Atoi(maxConcStr)
@@ -32,18 +85,191 @@ func main() {
 		maxConc = 4
 	}
 
-	kvEndpointsStr := os.Getenv("KV_ENDPOINTS")
-	var kvEndpoints []string
-	if kvEndpointsStr != "" {
-		for _, endp := range strings.Split(kvEndpointsStr, ",") {
-			endp = strings.TrimSpace(endp)
-			kvEndpoints = append(kvEndpoints, endp)
+	maxQueue := getenvInt("MAX_QUEUE_DEPTH", 16)
+	maxQueueWait := getenvDuration("MAX_QUEUE_WAIT", 10*time.Second)
+
+	backendTimeout := getenvDuration("BACKEND_TIMEOUT", 30*time.Second)
+	// A zero drain period, allowed by the CRD, skips the wait.
+	drainPeriod := getenvDurationOrZero("DRAIN_PERIOD", 5*time.Second)
+	shutdownTimeout := getenvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	sched, err := newSchedPolicy(splitList(os.Getenv("SCHED_TIERS")),
+		os.Getenv("SCHED_DEFAULT_TIER"), os.Getenv("SCHED_TENANT_WEIGHTS"))
+	if err != nil {
+		log.Fatalf("invalid scheduling policy: %v", err)
+	}
+
+	var auth *keyStore
+	if dir := os.Getenv("AUTH_DIR"); dir != "" {
+		if auth, err = newKeyStore(dir); err != nil {
+			log.Fatalf("loading API keys from %s: %v", dir, err)
+		}
+	}
+	authReload := getenvDuration("AUTH_RELOAD_INTERVAL", 10*time.Second)
+
+	limits, err := newRateLimiterFromEnv()
+	if err != nil {
+		log.Fatalf("invalid rate limits: %v", err)
+	}
+
+	audit, err := newAuditLogFromEnv()
+	if err != nil {
+		log.Fatalf("opening audit log: %v", err)
+	}
+	if audit != nil {
+		log.Printf("writing audit log to %s", audit.path)
+		// Deferred so the file is also closed when shutdown times out
+		// and in-flight requests are cut off.
+		defer func() { _ = audit.Close() }()
+	}
+
+	batchMaxSize := getenvInt("BATCH_MAX_SIZE", 0)
+	batchMaxWait := getenvDuration("BATCH_MAX_WAIT", 10*time.Millisecond)
+
+	prefix := prefixHasher{
+		blockTokens: getenvInt("PREFIX_BLOCK_TOKENS", 16),
+		maxBlocks:   getenvInt("PREFIX_MAX_BLOCKS", 4),
+	}
+
+	kvEndpoints := splitList(os.Getenv("KV_ENDPOINTS"))
+	kvRefresh := getenvDuration("KV_REFRESH_INTERVAL", 10*time.Second)
+	backendEndpoints := splitList(os.Getenv("BACKEND_ENDPOINTS"))
+	for i, endp := range backendEndpoints {
+		if !strings.Contains(endp, "://") {
+			endp = "http://" + endp
+		}
+		backendEndpoints[i] = strings.TrimRight(endp, "/")
+	}
+
+	log.Printf("starting router with modelRef=%q, maxConcurrency=%d, maxQueueDepth=%d, maxQueueWait=%s, "+
+		"tiers=%v, batchMaxSize=%d, batchMaxWait=%s, kvEndpoints=%v, backends=%v",
+		modelRef, maxConc, maxQueue, maxQueueWait,
+		sched.tiers, batchMaxSize, batchMaxWait, kvEndpoints, backendEndpoints)
+
+	rt := &router{
+		modelRef:       modelRef,
+		routerPod:      os.Getenv("HOSTNAME"),
+		backends:       newBackendPool(backendEndpoints, &http.Client{}),
+		backendTimeout: backendTimeout,
+		admission:      newAdmissionQueue(maxConc, maxQueue, maxQueueWait),
+		sched:          sched,
+		auth:           auth,
+		audit:          audit,
+		drainPeriod:    drainPeriod,
+	}
+	rt.backends.prefix = prefix
+	rt.kv.Store(newKVState(kvEndpoints))
+	rt.metrics = newRouterMetrics(modelRef, rt.admission)
+	rt.backends.onError = rt.metrics.BackendError
+	if limits != nil {
+		rt.limits = limits
+		rt.limits.onLimited = rt.metrics.RateLimited
+		if getenv("RATE_LIMIT_SHARED", "false") == "true" {
+			rt.limits.store = newRedisBuckets(rt.kvNodeFor, rt.limits.store)
 		}
 	}
+	if batchMaxSize > 1 {
+		rt.enableBatching(batchMaxSize, batchMaxWait)
+	}
 
-	log.Printf("starting router with modelRef=%q, maxConcurrency=%d, kvEndpoints=%v",
-		modelRef, maxConc, kvEndpoints)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
+	if auth != nil {
+		go auth.Run(ctx, authReload)
+	}
+
+	if len(kvEndpoints) > 0 {
+		disc := newKVDiscovery(kvEndpoints, net.DefaultResolver, kvRefresh, func(endpoints []string) {
+			rt.kv.Store(newKVState(endpoints))
+		})
+		go disc.Run(ctx)
+	}
+
+	addr := ":5678"
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: rt.routes(),
+	}
+	admin := &http.Server{
+		Addr:    adminAddr,
+		Handler: rt.adminRoutes(),
+	}
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Printf("router listening on %s", addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+	go func() {
+		log.Printf("admin endpoints listening on %s", adminAddr)
+		serveErr <- admin.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("router server error: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutdown signal received, draining")
+	rt.drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	_ = admin.Close()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("in-flight requests did not finish within %s, closing: %v", shutdownTimeout, err)
+		_ = srv.Close()
+		return
+	}
+
+	// Wait for in-flight requests
+	rt.wg.Wait()
+	log.Printf("router stopped")
+}
+
+// drain flips /readyz to not-ready and keeps serving for the drain period,
+// giving Service endpoints time to drop the pod before the listener closes.
+// It is triggered by the preStop hook and again by SIGTERM; only the first
+// call waits.
+func (rt *router) drain() {
+	rt.drainOnce.Do(func() {
+		rt.draining.Store(true)
+		time.Sleep(rt.drainPeriod)
+	})
+}
+
+// adminRoutes serves the endpoints only the router's own pod may call, on
+// ADMIN_ADDR.
+func (rt *router) adminRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+	// Called by the pod's preStop hook; returns once the drain period is over.
+	mux.HandleFunc("POST /drain", func(w http.ResponseWriter, r *http.Request) {
+		rt.drain()
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("drained"))
+	})
+	return mux
+}
+
+// requestDrain asks the router serving admin endpoints on addr to drain
+// and waits until it has.
+func requestDrain(addr string) error {
+	resp, err := http.Post("http://"+addr+"/drain", "", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("router answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (rt *router) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -52,58 +278,268 @@ func main() {
 	})
 
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Queue-Depth", strconv.Itoa(rt.admission.Queued()))
+		w.Header().Set("X-In-Flight", strconv.Itoa(rt.admission.InFlight()))
+		if rt.draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("draining"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ready"))
 	})
 
-	sem := make(chan struct{}, maxConc)
-	var wg sync.WaitGroup
+	mux.Handle("/metrics", rt.metrics.Handler())
+
+	// The generation endpoints are counted, audited and rate limited, in
+	// that order.
+	generation := func(path string, h http.HandlerFunc) {
+		mux.HandleFunc(path, rt.metrics.instrument(path, rt.audited(path, rt.limited(h))))
+	}
+	generation("/infer", rt.handleInfer)
+	generation("/v1/completions", rt.handleCompletions)
+	generation("/v1/chat/completions", rt.handleChatCompletions)
+	return mux
+}
+
+// enableBatching groups non-streaming generations into batches of up to
+// maxSize. Every request waiting for its batch holds an admission slot, so
+// a batch larger than MAX_CONCURRENCY would never fill; maxSize is capped
+// to it.
+func (rt *router) enableBatching(maxSize int, maxWait time.Duration) {
+	rt.batcher = newBatcher(rt.backends, rt.modelRef, min(maxSize, rt.admission.capacity), maxWait, rt.backendTimeout)
+	rt.batcher.onFlush = rt.metrics.BatchSent
+}
 
-	mux.HandleFunc("/infer", func(w http.ResponseWriter, r *http.Request) {
+// limited rejects non-POST requests, authenticates the caller, enforces the
+// tenant's rate limits and runs h while holding one of the router's
+// MAX_CONCURRENCY slots, queued by the request's priority tier and tenant.
+// Requests that cannot get a slot are shed with 429 when the wait queue is
+// full and 503 when they wait too long.
+func (rt *router) limited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		sem <- struct{}{}
-		wg.Add(1)
-		defer func() {
-			<-sem
-			wg.Done()
-		}()
+		tier, tenant := r.Header.Get(priorityHeader), r.Header.Get(tenantHeader)
+		if rt.auth != nil {
+			id, ok := rt.auth.authenticate(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="llama-shepherd"`)
+				http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+				return
+			}
+			// The key decides who the caller is; headers cannot
+			// override it.
+			tenant = id.tenant
+			if id.tier != "" {
+				tier = id.tier
+			}
+		}
+		class := rt.sched.class(tier, tenant)
+		noteClass(r.Context(), rt.sched.tierName(class.tier), class.tenant)
+		if rt.limits != nil {
+			var ok bool
+			if r, ok = rt.limits.admit(w, r, class.tenant); !ok {
+				return
+			}
+		}
 
-		start := time.Now()
-		var req InferRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		release, err := rt.admission.Acquire(r.Context(), class)
+		if err != nil {
+			// The request never ran; it should not count against
+			// the tenant's rate limits.
+			refundQuota(r.Context())
+		}
+		switch {
+		case errors.Is(err, errQueueFull):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, errQueueTimeout):
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			// Client gave up while queued.
 			return
 		}
+		rt.wg.Add(1)
+		defer func() {
+			release()
+			rt.wg.Done()
+		}()
 
-		// simulate work
-		time.Sleep(50 * time.Millisecond)
-		resp := InferResponse{
-			ModelRef:     modelRef,
-			Prompt:       req.Prompt,
-			KVEndpoints:  kvEndpoints,
-			ProcessingMs: time.Since(start).Milliseconds(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			log.Printf("failed to write response: %v", err)
+		h(w, r)
+	}
+}
+
+// generate runs req against the backend pool, bounded by BACKEND_TIMEOUT.
+// With batching enabled the request may wait up to BATCH_MAX_WAIT to share
+// an upstream call with others.
+func (rt *router) generate(ctx context.Context, req GenerateRequest) (*GenerateResponse, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, rt.backendTimeout)
+	defer cancel()
+
+	rt.route(&req)
+	generate := rt.backends.Generate
+	if rt.batcher != nil {
+		generate = rt.batcher.Generate
+	}
+	out, backend, err := generate(ctx, req)
+	noteBackend(ctx, backend)
+	if err == nil {
+		chargeCompletion(ctx, cmp.Or(out.CompletionTokens, estimateTokens(out.Text)))
+	}
+	return out, backend, err
+}
+
+// kvEndpoints returns the KV cache nodes the router currently knows about.
+func (rt *router) kvEndpoints() []string {
+	if kv := rt.kv.Load(); kv != nil {
+		return kv.endpoints
+	}
+	return nil
+}
+
+// route fills in the parts of req the router decides: the model and the KV
+// cache node that owns the prompt's prefix.
+func (rt *router) route(req *GenerateRequest) {
+	req.Model = rt.modelRef
+	req.KVEndpoint = rt.kvEndpointFor(req.Prompt)
+}
+
+// kvNodeFor returns the KV cache node that owns key on the hash ring, or ""
+// if none is known.
+func (rt *router) kvNodeFor(key string) string {
+	kv := rt.kv.Load()
+	if kv == nil {
+		return ""
+	}
+	if nodes := kv.ring.Lookup(hashString(key)); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// kvEndpointFor picks the KV cache node for prompt from the consistent-hash
+// ring over the discovered KV cache nodes, using the same prefix key as backend selection so
+// a prefix keeps landing on the same backend and cache node.
+func (rt *router) kvEndpointFor(prompt string) string {
+	key, ok := rt.backends.prefix.Key(prompt)
+	kv := rt.kv.Load()
+	if !ok || kv == nil {
+		return ""
+	}
+	if nodes := kv.ring.Lookup(key); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// errFirstChunkTimeout marks a stream whose backend produced nothing within
+// BACKEND_TIMEOUT.
+var errFirstChunkTimeout = fmt.Errorf("no output within backend timeout: %w", context.DeadlineExceeded)
+
+// generateStream streams req from the backend pool. BACKEND_TIMEOUT only
+// bounds the wait for the first chunk, so long generations are not cut off
+// once tokens are flowing.
+func (rt *router) generateStream(ctx context.Context, req GenerateRequest,
+	onChunk func(GenerateChunk) error) (string, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(rt.backendTimeout, func() { cancel(errFirstChunkTimeout) })
+	defer timer.Stop()
+
+	rt.route(&req)
+	var generated strings.Builder
+	backend, err := rt.backends.GenerateStream(ctx, req, func(c GenerateChunk) error {
+		timer.Stop()
+		generated.WriteString(c.Text)
+		return onChunk(c)
+	})
+	noteBackend(ctx, backend)
+	chargeCompletion(ctx, estimateTokens(generated.String()))
+	if err != nil && errors.Is(context.Cause(ctx), errFirstChunkTimeout) {
+		err = errFirstChunkTimeout
+	}
+	return backend, err
+}
+
+// streamResponse relays a streamed generation to the client as server-sent
+// events, ending with [DONE]. event converts each backend chunk into the
+// payload the client sees; writeErr answers failures that happen before the
+// first event, while later failures are reported as an error event.
+func (rt *router) streamResponse(w http.ResponseWriter, r *http.Request, req GenerateRequest,
+	event func(GenerateChunk) any, writeErr func(http.ResponseWriter, error)) {
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	backend, err := rt.generateStream(r.Context(), req, func(c GenerateChunk) error {
+		if !sse.Started() {
+			rt.metrics.FirstChunk(r.Context())
 		}
+		return sse.Event(event(c))
 	})
-	addr := ":5678"
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+	if err != nil {
+		if r.Context().Err() != nil {
+			// Client disconnected; the backend request has been cancelled too.
+			return
+		}
+		log.Printf("stream failed (backend=%q): %v", backend, err)
+		if !sse.Started() {
+			writeErr(w, err)
+			return
+		}
+		_, msg := backendErrorStatus(err)
+		_ = sse.Event(struct {
+			Error openAIError `json:"error"`
+		}{openAIError{Message: msg, Type: "server_error"}})
+		return
 	}
-	log.Printf("router listening on %s", addr)
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("router server error: %v", err)
+	_ = sse.Done()
+}
+
+func (rt *router) handleInfer(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var req InferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
 	}
 
-	// Wait for in-flight requests
-	wg.Wait()
+	if req.Stream {
+		rt.streamResponse(w, r, GenerateRequest{Prompt: req.Prompt},
+			func(c GenerateChunk) any { return c }, writeBackendError)
+		return
+	}
+
+	out, backend, err := rt.generate(r.Context(), GenerateRequest{Prompt: req.Prompt})
+	if err != nil {
+		log.Printf("infer failed (backend=%q): %v", backend, err)
+		writeBackendError(w, err)
+		return
+	}
+
+	resp := InferResponse{
+		ModelRef:     rt.modelRef,
+		Prompt:       req.Prompt,
+		Output:       out.Text,
+		Backend:      backend,
+		RouterPod:    rt.routerPod,
+		KVEndpoint:   rt.kvEndpointFor(req.Prompt),
+		KVEndpoints:  rt.kvEndpoints(),
+		ProcessingMs: time.Since(start).Milliseconds(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
 }
 
 func getenv(key, def string) string {
@@ -112,3 +548,103 @@ func getenv(key, def string) string {
 	}
 	return def
 }
+
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s=%q, defaulting to %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	return parseEnvDuration(key, def, false)
+}
+
+// getenvDurationOrZero is getenvDuration for settings where zero turns a
+// wait off.
+func getenvDurationOrZero(key string, def time.Duration) time.Duration {
+	return parseEnvDuration(key, def, true)
+}
+
+func parseEnvDuration(key string, def time.Duration, allowZero bool) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 || d == 0 && !allowZero {
+		log.Printf("invalid %s=%q, defaulting to %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// newRateLimiterFromEnv reads the RATE_LIMIT_* settings. It returns nil if
+// no limits are configured.
+func newRateLimiterFromEnv() (*rateLimiter, error) {
+	tenants, err := parseTenantRateLimits(os.Getenv("RATE_LIMIT_TENANTS"))
+	if err != nil {
+		return nil, err
+	}
+	var def rateLimit
+	for key, dst := range map[string]*float64{
+		"RATE_LIMIT_RPS":   &def.rps,
+		"RATE_LIMIT_BURST": &def.burst,
+		"RATE_LIMIT_TPM":   &def.tpm,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		if *dst, err = strconv.ParseFloat(v, 64); err != nil || *dst < 0 {
+			return nil, fmt.Errorf("invalid %s=%q", key, v)
+		}
+	}
+	if def == (rateLimit{}) && len(tenants) == 0 {
+		return nil, nil
+	}
+	return &rateLimiter{
+		defaults: def,
+		tenants:  tenants,
+		scope:    getenv("RATE_LIMIT_SCOPE", getenv("MODEL_REF", "")),
+		store:    newLocalBuckets(),
+		maxBody:  int64(getenvInt("RATE_LIMIT_MAX_BODY_BYTES", defaultRateLimitMaxBody)),
+	}, nil
+}
+
+// newAuditLogFromEnv opens the audit log under AUDIT_DIR, one file per
+// router pod. It returns nil if AUDIT_DIR is not set.
+func newAuditLogFromEnv() (*auditLog, error) {
+	dir := os.Getenv("AUDIT_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	redact, err := parseRedaction(splitList(os.Getenv("AUDIT_REDACT")), getenv("AUDIT_REDACT_HASH", "false") == "true")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "requests-"+getenv("HOSTNAME", "router")+".jsonl")
+	return openAuditLog(path,
+		int64(getenvInt("AUDIT_MAX_SIZE_MB", 100))<<20,
+		getenvInt("AUDIT_MAX_FILES", 5),
+		getenvInt("AUDIT_MAX_BODY_BYTES", 1<<20),
+		redact)
+}
+
+// splitList parses a comma-separated env value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBackend is a stand-in model server that upper-cases the prompt.
+func fakeBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(GenerateResponse{Text: strings.ToUpper(req.Prompt)})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestRouter(backends ...string) *router {
+	rt := &router{
+		modelRef:       "test-model",
+		backends:       newBackendPool(backends, &http.Client{}),
+		backendTimeout: time.Second,
+		admission:      newAdmissionQueue(4, 4, time.Second),
+	}
+	rt.metrics = newRouterMetrics(rt.modelRef, rt.admission)
+	rt.backends.onError = rt.metrics.BackendError
+	return rt
+}
+
+func postJSON(t *testing.T, rt *router, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	rt.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestInferForwardsToBackend(t *testing.T) {
+	backend := fakeBackend(t)
+	rt := newTestRouter(backend.URL)
+
+	rec := postJSON(t, rt, "/infer", `{"prompt":"hello"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	var resp InferResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Output != "HELLO" || resp.Backend != backend.URL || resp.ModelRef != "test-model" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestInferPropagatesUpstreamStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model overloaded", http.StatusTooManyRequests)
+	}))
+	defer backend.Close()
+
+	rec := postJSON(t, newTestRouter(backend.URL), "/infer", `{"prompt":"hello"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if !strings.Contains(rec.Body.String(), "model overloaded") {
+		t.Fatalf("body = %q", rec.Body.String())
+	}
+}
+
+func TestInferFailsOverUnreachableBackend(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	backend := fakeBackend(t)
+
+	rt := newTestRouter(dead.URL, backend.URL)
+	for i := 0; i < 2; i++ {
+		rec := postJSON(t, rt, "/infer", `{"prompt":"hi"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, body = %q", i, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestInferBackendTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	rt := newTestRouter(backend.URL)
+	rt.backendTimeout = 50 * time.Millisecond
+	rec := postJSON(t, rt, "/infer", `{"prompt":"hello"}`)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestInferWithoutBackends(t *testing.T) {
+	rec := postJSON(t, newTestRouter(), "/infer", `{"prompt":"hello"}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestDrainFlipsReadiness(t *testing.T) {
+	rt := newTestRouter()
+	mux := rt.routes()
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("/readyz before drain = %d", code)
+	}
+	// The drain hook is not reachable through the Service port.
+	if code := get("/drain"); code != http.StatusNotFound {
+		t.Fatalf("/drain on the public port = %d, want 404", code)
+	}
+	admin := httptest.NewServer(rt.adminRoutes())
+	defer admin.Close()
+	if err := requestDrain(strings.TrimPrefix(admin.URL, "http://")); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz after drain = %d", code)
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("/healthz after drain = %d", code)
+	}
+	rec := postJSON(t, rt, "/infer", `{"prompt":"hi"}`)
+	if rec.Code == http.StatusNotFound || rec.Code == http.StatusMethodNotAllowed {
+		t.Fatalf("requests should still be served while draining, got %d", rec.Code)
+	}
+}
+
+func TestGetenvDurationOrZero(t *testing.T) {
+	t.Setenv("DRAIN_PERIOD", "0s")
+	if d := getenvDurationOrZero("DRAIN_PERIOD", 5*time.Second); d != 0 {
+		t.Fatalf("DRAIN_PERIOD=0s parsed as %s, want 0", d)
+	}
+	if d := getenvDuration("DRAIN_PERIOD", 5*time.Second); d != 5*time.Second {
+		t.Fatalf("getenvDuration(0s) = %s, want the default", d)
+	}
+	t.Setenv("DRAIN_PERIOD", "-1s")
+	if d := getenvDurationOrZero("DRAIN_PERIOD", 5*time.Second); d != 5*time.Second {
+		t.Fatalf("DRAIN_PERIOD=-1s parsed as %s, want the default", d)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// routerMetrics holds the Prometheus collectors exported on /metrics. Every
+// series carries a constant "model" label set to MODEL_REF.
+type routerMetrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	duration         *prometheus.HistogramVec
+	timeToFirstToken *prometheus.HistogramVec
+	backendErrors    *prometheus.CounterVec
+	batchSize        prometheus.Histogram
+	rateLimited      *prometheus.CounterVec
+}
+
+func newRouterMetrics(modelRef string, admission *admissionQueue) *routerMetrics {
+	reg := prometheus.NewRegistry()
+	m := &routerMetrics{
+		registry: reg,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "router_requests_total",
+			Help: "Inference requests handled by the router, by endpoint and HTTP status code.",
+		}, []string{"endpoint", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "router_request_duration_seconds",
+			Help:    "End-to-end latency of inference requests, including time spent queued.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"endpoint"}),
+		timeToFirstToken: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "router_time_to_first_token_seconds",
+			Help:    "Time from request arrival until the first streamed chunk is sent.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"endpoint"}),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "router_backend_errors_total",
+			Help: "Failed calls to model-server backends, by backend and reason.",
+		}, []string{"backend", "reason"}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "router_batch_size",
+			Help:    "Requests per upstream call when micro-batching is enabled.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 9),
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "router_rate_limited_total",
+			Help: "Requests rejected by per-tenant rate limits, by the quota that was exhausted.",
+		}, []string{"quota"}),
+	}
+
+	labelled := prometheus.WrapRegistererWith(prometheus.Labels{"model": modelRef}, reg)
+	labelled.MustRegister(m.requests, m.duration, m.timeToFirstToken, m.backendErrors, m.batchSize, m.rateLimited)
+	labelled.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "router_inflight_requests",
+			Help: "Requests currently holding a concurrency slot.",
+		}, func() float64 { return float64(admission.InFlight()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "router_queued_requests",
+			Help: "Requests waiting in the admission queue.",
+		}, func() float64 { return float64(admission.Queued()) }),
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *routerMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// BackendError counts a failed call to backend.
+func (m *routerMetrics) BackendError(backend, reason string) {
+	m.backendErrors.WithLabelValues(backend, reason).Inc()
+}
+
+// BatchSent records the size of a batch sent upstream.
+func (m *routerMetrics) BatchSent(size int) {
+	m.batchSize.Observe(float64(size))
+}
+
+// RateLimited counts a request rejected because quota ran out.
+func (m *routerMetrics) RateLimited(quota string) {
+	m.rateLimited.WithLabelValues(quota).Inc()
+}
+
+type requestInfoKey struct{}
+
+// requestInfo is what instrument stashes in the request context for
+// handlers that record additional metrics.
+type requestInfo struct {
+	endpoint string
+	start    time.Time
+}
+
+// instrument records the request count, status code and latency of h under
+// the given endpoint label. It wraps the admission queue so shed requests
+// are counted too.
+func (m *routerMetrics) instrument(endpoint string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, requestInfo{endpoint, start}))
+
+		h(rec, r)
+
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(rec.Status())).Inc()
+		m.duration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+// FirstChunk records the time to first token for a streamed request.
+func (m *routerMetrics) FirstChunk(ctx context.Context) {
+	info, ok := ctx.Value(requestInfoKey{}).(requestInfo)
+	if !ok {
+		return
+	}
+	m.timeToFirstToken.WithLabelValues(info.endpoint).Observe(time.Since(info.start).Seconds())
+}
+
+// statusRecorder captures the status code written by a handler while still
+// letting streaming handlers flush.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the recorded status code. A handler that wrote nothing,
+// typically because the client went away, is reported as 499 in the style
+// of nginx.
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return 499
+	}
+	return s.status
+}
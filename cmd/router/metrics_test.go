@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, rt *router) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	rt.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics status = %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsRecordRequests(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	rt := newTestRouter(streamingBackend(t).URL)
+	postJSON(t, rt, "/infer", `{"prompt":"a b","stream":true}`)
+	postJSON(t, rt, "/infer", `not json`)
+	rt.backends = newBackendPool([]string{failing.URL}, &http.Client{})
+	rt.backends.onError = rt.metrics.BackendError
+	postJSON(t, rt, "/v1/completions", `{"prompt":"hi"}`)
+
+	out := scrape(t, rt)
+	for _, want := range []string{
+		`router_requests_total{code="200",endpoint="/infer",model="test-model"} 1`,
+		`router_requests_total{code="400",endpoint="/infer",model="test-model"} 1`,
+		`router_requests_total{code="503",endpoint="/v1/completions",model="test-model"} 1`,
+		`router_request_duration_seconds_count{endpoint="/infer",model="test-model"} 2`,
+		`router_time_to_first_token_seconds_count{endpoint="/infer",model="test-model"} 1`,
+		`router_backend_errors_total{backend="` + failing.URL + `",model="test-model",reason="http_503"} 1`,
+		`router_inflight_requests{model="test-model"} 0`,
+		`router_queued_requests{model="test-model"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics output is missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stopSequences accepts OpenAI's "stop" field, which may be a single string
+// or an array of strings.
+type stopSequences []string
+
+func (s *stopSequences) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		if one != "" {
+			*s = stopSequences{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+// CompletionRequest is the body of POST /v1/completions.
+type CompletionRequest struct {
+	Model       string        `json:"model"`
+	Prompt      string        `json:"prompt"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	Stop        stopSequences `json:"stop,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+}
+
+// ChatMessage is a single turn in a chat conversation.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatCompletionRequest is the body of POST /v1/chat/completions.
+type ChatCompletionRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	Stop        stopSequences `json:"stop,omitempty"`
+	Stream      bool          `json:"stream,omitempty"`
+}
+
+// Usage reports token accounting for a completion.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type CompletionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type CompletionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+	Usage   Usage              `json:"usage"`
+}
+
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   Usage                  `json:"usage"`
+}
+
+// CompletionChunk is one streamed event of POST /v1/completions.
+type CompletionChunk struct {
+	ID      string                  `json:"id"`
+	Object  string                  `json:"object"`
+	Created int64                   `json:"created"`
+	Model   string                  `json:"model"`
+	Choices []CompletionChunkChoice `json:"choices"`
+}
+
+type CompletionChunkChoice struct {
+	Index        int     `json:"index"`
+	Text         string  `json:"text"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// ChatCompletionChunk is one streamed event of POST /v1/chat/completions.
+type ChatCompletionChunk struct {
+	ID      string                      `json:"id"`
+	Object  string                      `json:"object"`
+	Created int64                       `json:"created"`
+	Model   string                      `json:"model"`
+	Choices []ChatCompletionChunkChoice `json:"choices"`
+}
+
+type ChatCompletionChunkChoice struct {
+	Index        int       `json:"index"`
+	Delta        ChatDelta `json:"delta"`
+	FinishReason *string   `json:"finish_reason"`
+}
+
+// ChatDelta is the incremental part of a streamed chat message; Role is
+// only set on the first chunk.
+type ChatDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (rt *router) handleCompletions(w http.ResponseWriter, r *http.Request) {
+	var req CompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "invalid JSON body: "+err.Error())
+		return
+	}
+	if !rt.checkModel(w, req.Model) {
+		return
+	}
+
+	genReq := GenerateRequest{
+		Prompt:      req.Prompt,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stop:        req.Stop,
+	}
+	if req.Stream {
+		id, created := newCompletionID("cmpl"), time.Now().Unix()
+		rt.streamResponse(w, r, genReq, func(c GenerateChunk) any {
+			return CompletionChunk{
+				ID:      id,
+				Object:  "text_completion",
+				Created: created,
+				Model:   rt.modelRef,
+				Choices: []CompletionChunkChoice{{
+					Text:         c.Text,
+					FinishReason: chunkFinishReason(c),
+				}},
+			}
+		}, writeOpenAIBackendError)
+		return
+	}
+
+	out, backend, err := rt.generate(r.Context(), genReq)
+	if err != nil {
+		log.Printf("completion failed (backend=%q): %v", backend, err)
+		writeOpenAIBackendError(w, err)
+		return
+	}
+
+	writeJSON(w, CompletionResponse{
+		ID:      newCompletionID("cmpl"),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   rt.modelRef,
+		Choices: []CompletionChoice{{
+			Text:         out.Text,
+			FinishReason: finishReason(out),
+		}},
+		Usage: usageFor(req.Prompt, out),
+	})
+}
+
+func (rt *router) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "invalid JSON body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "messages must not be empty")
+		return
+	}
+	if !rt.checkModel(w, req.Model) {
+		return
+	}
+
+	prompt := renderChatPrompt(req.Messages)
+	genReq := GenerateRequest{
+		Prompt:      prompt,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stop:        req.Stop,
+	}
+	if req.Stream {
+		id, created := newCompletionID("chatcmpl"), time.Now().Unix()
+		first := true
+		rt.streamResponse(w, r, genReq, func(c GenerateChunk) any {
+			delta := ChatDelta{Content: c.Text}
+			if first {
+				delta.Role = "assistant"
+				first = false
+			}
+			return ChatCompletionChunk{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   rt.modelRef,
+				Choices: []ChatCompletionChunkChoice{{
+					Delta:        delta,
+					FinishReason: chunkFinishReason(c),
+				}},
+			}
+		}, writeOpenAIBackendError)
+		return
+	}
+
+	out, backend, err := rt.generate(r.Context(), genReq)
+	if err != nil {
+		log.Printf("chat completion failed (backend=%q): %v", backend, err)
+		writeOpenAIBackendError(w, err)
+		return
+	}
+
+	writeJSON(w, ChatCompletionResponse{
+		ID:      newCompletionID("chatcmpl"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   rt.modelRef,
+		Choices: []ChatCompletionChoice{{
+			Message:      ChatMessage{Role: "assistant", Content: out.Text},
+			FinishReason: finishReason(out),
+		}},
+		Usage: usageFor(prompt, out),
+	})
+}
+
+// checkModel accepts requests that name the router's MODEL_REF or leave the
+// model empty, and answers anything else with OpenAI's model_not_found.
+func (rt *router) checkModel(w http.ResponseWriter, model string) bool {
+	if model == "" || model == rt.modelRef {
+		return true
+	}
+	writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("model %q is not served here; this endpoint serves %q", model, rt.modelRef))
+	return false
+}
+
+// renderChatPrompt flattens chat messages into a single prompt, one
+// "role: content" line per message, ending with an open assistant turn.
+func renderChatPrompt(msgs []ChatMessage) string {
+	var b strings.Builder
+	for _, m := range msgs {
+		b.WriteString(m.Role)
+		b.WriteString(": ")
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+	}
+	b.WriteString("assistant:")
+	return b.String()
+}
+
+func finishReason(out *GenerateResponse) string {
+	if out.FinishReason != "" {
+		return out.FinishReason
+	}
+	return "stop"
+}
+
+// chunkFinishReason returns the finish reason of a streamed chunk, or nil
+// (JSON null) while the generation is still running.
+func chunkFinishReason(c GenerateChunk) *string {
+	if c.FinishReason == "" {
+		return nil
+	}
+	return &c.FinishReason
+}
+
+// usageFor builds the usage block, preferring the backend's token counts and
+// falling back to an estimate when it does not report them.
+func usageFor(prompt string, out *GenerateResponse) Usage {
+	u := Usage{
+		PromptTokens:     out.PromptTokens,
+		CompletionTokens: out.CompletionTokens,
+	}
+	if u.PromptTokens == 0 {
+		u.PromptTokens = estimateTokens(prompt)
+	}
+	if u.CompletionTokens == 0 {
+		u.CompletionTokens = estimateTokens(out.Text)
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	return u
+}
+
+// estimateTokens approximates a token count at roughly four bytes per token,
+// the usual rule of thumb for BPE tokenizers on English text.
+func estimateTokens(s string) int {
+	if s == "" {
+		return 0
+	}
+	return (len(s) + 3) / 4
+}
+
+func newCompletionID(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + "-" + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, typ, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error openAIError `json:"error"`
+	}{openAIError{Message: msg, Type: typ, Code: code}})
+}
+
+// writeOpenAIBackendError reports a backend failure in OpenAI's error format.
+func writeOpenAIBackendError(w http.ResponseWriter, err error) {
+	status, msg := backendErrorStatus(err)
+	if status == 0 {
+		return
+	}
+	typ := "server_error"
+	if status < 500 {
+		typ = "invalid_request_error"
+	}
+	writeOpenAIError(w, status, typ, "", msg)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestChatCompletions(t *testing.T) {
+	var got GenerateRequest
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(GenerateResponse{Text: "hi there", PromptTokens: 7, CompletionTokens: 2})
+	}))
+	defer backend.Close()
+
+	rec := postJSON(t, newTestRouter(backend.URL), "/v1/chat/completions", `{
+		"model": "test-model",
+		"messages": [{"role": "system", "content": "be brief"}, {"role": "user", "content": "hello"}],
+		"max_tokens": 16,
+		"temperature": 0.2,
+		"stop": "\n"
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	if got.Model != "test-model" || got.MaxTokens != 16 || got.Temperature == nil || *got.Temperature != 0.2 {
+		t.Fatalf("backend request = %+v", got)
+	}
+	if !reflect.DeepEqual(got.Stop, []string{"\n"}) {
+		t.Fatalf("backend stop = %q", got.Stop)
+	}
+	if got.Prompt != "system: be brief\nuser: hello\nassistant:" {
+		t.Fatalf("backend prompt = %q", got.Prompt)
+	}
+
+	var resp ChatCompletionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "chat.completion" || resp.Model != "test-model" || len(resp.Choices) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if c := resp.Choices[0]; c.Message.Role != "assistant" || c.Message.Content != "hi there" || c.FinishReason != "stop" {
+		t.Fatalf("unexpected choice: %+v", c)
+	}
+	if resp.Usage != (Usage{PromptTokens: 7, CompletionTokens: 2, TotalTokens: 9}) {
+		t.Fatalf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestCompletionsEstimatesUsage(t *testing.T) {
+	backend := fakeBackend(t)
+
+	rec := postJSON(t, newTestRouter(backend.URL), "/v1/completions",
+		`{"prompt": "abcdefgh", "stop": ["x", "y"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	var resp CompletionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Object != "text_completion" || resp.Choices[0].Text != "ABCDEFGH" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if resp.Usage != (Usage{PromptTokens: 2, CompletionTokens: 2, TotalTokens: 4}) {
+		t.Fatalf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestCompletionsUnknownModel(t *testing.T) {
+	rec := postJSON(t, newTestRouter(fakeBackend(t).URL), "/v1/completions",
+		`{"model": "other-model", "prompt": "hi"}`)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	var body struct {
+		Error openAIError `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Code != "model_not_found" {
+		t.Fatalf("error = %+v", body.Error)
+	}
+}
+
+func TestChatCompletionsBackendError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	rec := postJSON(t, newTestRouter(backend.URL), "/v1/chat/completions",
+		`{"messages": [{"role": "user", "content": "hi"}]}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	var body struct {
+		Error openAIError `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Type != "server_error" || body.Error.Message != "boom" {
+		t.Fatalf("error = %+v", body.Error)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// priorityHeader names the priority tier a request asks for.
+	priorityHeader = "X-Priority"
+	// tenantHeader names the tenant a request is queued under.
+	tenantHeader = "X-Tenant"
+)
+
+// schedPolicy maps requests onto the priority tiers and tenant weights the
+// admission queue schedules by.
+type schedPolicy struct {
+	// tiers are the tier names, highest priority first.
+	tiers []string
+	// defaultTier is the tier of requests that name none or an unknown
+	// one.
+	defaultTier int
+	// weights holds each listed tenant's share within a tier; everyone
+	// else has weight 1.
+	weights map[string]float64
+}
+
+// newSchedPolicy builds a policy from SCHED_TIERS, SCHED_DEFAULT_TIER and
+// SCHED_TENANT_WEIGHTS style values. An empty defaultTier means the lowest
+// tier, so unlabelled traffic cannot crowd out anything that asked for
+// priority.
+func newSchedPolicy(tiers []string, defaultTier, tenantWeights string) (schedPolicy, error) {
+	p := schedPolicy{tiers: tiers, weights: map[string]float64{}}
+	if len(tiers) > 0 {
+		p.defaultTier = len(tiers) - 1
+	}
+	if defaultTier != "" {
+		i := slices.Index(tiers, defaultTier)
+		if i < 0 {
+			return schedPolicy{}, fmt.Errorf("default tier %q is not one of %v", defaultTier, tiers)
+		}
+		p.defaultTier = i
+	}
+	for _, item := range splitList(tenantWeights) {
+		tenant, w, ok := strings.Cut(item, "=")
+		weight, err := strconv.ParseFloat(w, 64)
+		if !ok || tenant == "" || err != nil || weight <= 0 {
+			return schedPolicy{}, fmt.Errorf("invalid tenant weight %q, want tenant=weight", item)
+		}
+		p.weights[tenant] = weight
+	}
+	return p, nil
+}
+
+// class returns the requestClass for the named tier and tenant. Callers
+// name both with the X-Priority and X-Tenant headers unless an API key
+// decides them.
+func (p schedPolicy) class(tier, tenant string) requestClass {
+	c := requestClass{tier: p.defaultTier, tenant: tenant, weight: 1}
+	if i := slices.Index(p.tiers, tier); i >= 0 {
+		c.tier = i
+	}
+	if w, ok := p.weights[tenant]; ok {
+		c.weight = w
+	}
+	return c
+}
+
+// tierName returns the name of tier i, or "" if no tiers are declared.
+func (p schedPolicy) tierName(i int) string {
+	if i < len(p.tiers) {
+		return p.tiers[i]
+	}
+	return ""
+}
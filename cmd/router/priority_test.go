@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestSchedPolicyClass(t *testing.T) {
+	p, err := newSchedPolicy([]string{"interactive", "standard", "background"}, "standard", "chat=4, jobs=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		tier, tenant string
+		want         requestClass
+	}{
+		{"interactive", "chat", requestClass{tier: 0, tenant: "chat", weight: 4}},
+		{"background", "jobs", requestClass{tier: 2, tenant: "jobs", weight: 0.5}},
+		{"", "other", requestClass{tier: 1, tenant: "other", weight: 1}},
+		{"unknown", "", requestClass{tier: 1, weight: 1}},
+	} {
+		if got := p.class(tc.tier, tc.tenant); got != tc.want {
+			t.Errorf("class(%q, %q) = %+v, want %+v", tc.tier, tc.tenant, got, tc.want)
+		}
+	}
+}
+
+func TestSchedPolicyDefaultsToLowestTier(t *testing.T) {
+	p, err := newSchedPolicy([]string{"high", "low"}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.class("", "").tier; got != 1 {
+		t.Fatalf("default tier = %d, want 1", got)
+	}
+}
+
+func TestNewSchedPolicyRejectsBadConfig(t *testing.T) {
+	if _, err := newSchedPolicy([]string{"high"}, "missing", ""); err == nil {
+		t.Error("unknown default tier accepted")
+	}
+	for _, w := range []string{"chat", "chat=0", "=2", "chat=x"} {
+		if _, err := newSchedPolicy(nil, "", w); err == nil {
+			t.Errorf("tenant weights %q accepted", w)
+		}
+	}
+}
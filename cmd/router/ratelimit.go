@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimit is one tenant's quota. Zero rates are unlimited.
+type rateLimit struct {
+	// rps is the steady request rate and burst how many requests may
+	// arrive at once.
+	rps   float64
+	burst float64
+	// tpm is estimated prompt plus completion tokens per minute.
+	tpm float64
+}
+
+// bucketStore keeps token buckets by key.
+type bucketStore interface {
+	// Take removes n tokens from the bucket key, which refills at rate
+	// per second up to burst. It only takes them if that many are left,
+	// unless force is set, in which case the bucket may go into debt.
+	// A forced Take of a negative n gives tokens back, up to burst.
+	Take(ctx context.Context, key string, rate, burst, n float64, force bool) (bucketState, error)
+}
+
+// bucketState is a bucket after a Take.
+type bucketState struct {
+	allowed   bool
+	remaining float64
+	// retryAfter is how long until enough tokens are back, when the Take
+	// was refused.
+	retryAfter time.Duration
+}
+
+// rateLimiter enforces per-tenant token buckets on requests per second and
+// on estimated tokens per minute. Prompt tokens are estimated from the body
+// and taken up front; completion tokens are charged once the generation is
+// done, so a long generation delays the tenant's next requests instead of
+// failing halfway.
+type rateLimiter struct {
+	defaults rateLimit
+	tenants  map[string]rateLimit
+	// scope prefixes bucket keys so InferenceServices sharing a store do
+	// not share quotas.
+	scope string
+	store bucketStore
+	// maxBody caps the request body read to estimate its prompt tokens,
+	// zero meaning defaultRateLimitMaxBody. Larger bodies are rejected.
+	maxBody int64
+	// onLimited, if set, is told which quota rejected a request.
+	onLimited func(quota string)
+}
+
+// defaultRateLimitMaxBody is the default RATE_LIMIT_MAX_BODY_BYTES.
+const defaultRateLimitMaxBody = 1 << 20
+
+// parseTenantRateLimits parses RATE_LIMIT_TENANTS, a comma-separated list of
+// tenant=rps:burst:tpm entries.
+func parseTenantRateLimits(s string) (map[string]rateLimit, error) {
+	out := map[string]rateLimit{}
+	for _, item := range splitList(s) {
+		tenant, v, ok := strings.Cut(item, "=")
+		parts := strings.Split(v, ":")
+		if !ok || tenant == "" || len(parts) != 3 {
+			return nil, fmt.Errorf("invalid tenant rate limit %q, want tenant=rps:burst:tpm", item)
+		}
+		var vals [3]float64
+		for i, p := range parts {
+			f, err := strconv.ParseFloat(p, 64)
+			if err != nil || f < 0 {
+				return nil, fmt.Errorf("invalid tenant rate limit %q, want tenant=rps:burst:tpm", item)
+			}
+			vals[i] = f
+		}
+		out[tenant] = rateLimit{rps: vals[0], burst: vals[1], tpm: vals[2]}
+	}
+	return out, nil
+}
+
+func (l *rateLimiter) limitFor(tenant string) rateLimit {
+	lim, ok := l.tenants[tenant]
+	if !ok {
+		lim = l.defaults
+	}
+	if lim.burst < 1 {
+		lim.burst = max(1, math.Ceil(lim.rps))
+	}
+	return lim
+}
+
+func (l *rateLimiter) key(tenant, quota string) string {
+	return "ratelimit:" + l.scope + ":" + tenant + ":" + quota
+}
+
+// quotaKey is the context key of a request's *quota.
+type quotaKey struct{}
+
+// quota is an admitted request's hold on its tenant's token buckets.
+type quota struct {
+	limiter *rateLimiter
+	tenant  string
+	// requests and tokens are what admit took, given back by refund.
+	requests float64
+	tokens   float64
+}
+
+// admit checks r against its tenant's quotas, sets the X-RateLimit headers,
+// and answers 429 if either is used up. On success it returns r with the
+// tenant's quota in its context so the completion can be charged later.
+func (l *rateLimiter) admit(w http.ResponseWriter, r *http.Request, tenant string) (*http.Request, bool) {
+	lim := l.limitFor(tenant)
+	ctx := r.Context()
+	q := &quota{limiter: l, tenant: tenant}
+
+	if lim.rps > 0 {
+		st := l.take(ctx, l.key(tenant, "requests"), lim.rps, lim.burst, 1, false)
+		w.Header().Set("X-RateLimit-Limit-Requests", formatQuota(lim.rps))
+		w.Header().Set("X-RateLimit-Remaining-Requests", formatQuota(max(0, math.Floor(st.remaining))))
+		if !st.allowed {
+			l.limited(w, "requests", st.retryAfter)
+			return r, false
+		}
+		q.requests = 1
+	}
+	if lim.tpm > 0 {
+		// The body is read here to estimate the prompt and handed on
+		// unchanged. It is read before the request is admitted, so
+		// only up to maxBody of it is held.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cmp.Or(l.maxBody, defaultRateLimitMaxBody)))
+		if err != nil {
+			q.refund(ctx)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit),
+					http.StatusRequestEntityTooLarge)
+				return r, false
+			}
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return r, false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// A prompt larger than a minute's quota is let through once the
+		// bucket is full rather than never.
+		prompt := min(float64(estimateTokens(string(body))), lim.tpm)
+		st := l.take(ctx, l.key(tenant, "tokens"), lim.tpm/60, lim.tpm, prompt, false)
+		w.Header().Set("X-RateLimit-Limit-Tokens", formatQuota(lim.tpm))
+		w.Header().Set("X-RateLimit-Remaining-Tokens", formatQuota(max(0, math.Floor(st.remaining))))
+		if !st.allowed {
+			q.refund(ctx)
+			l.limited(w, "tokens", st.retryAfter)
+			return r, false
+		}
+		q.tokens = prompt
+	}
+	return r.WithContext(context.WithValue(ctx, quotaKey{}, q)), true
+}
+
+// refundQuota gives back what admit took for the request behind ctx. It is
+// for requests turned away before they ran, such as by a full admission
+// queue, so they do not use up their tenant's quota.
+func refundQuota(ctx context.Context) {
+	if q, ok := ctx.Value(quotaKey{}).(*quota); ok {
+		q.refund(ctx)
+	}
+}
+
+func (q *quota) refund(ctx context.Context) {
+	lim := q.limiter.limitFor(q.tenant)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	if q.requests > 0 {
+		q.limiter.take(ctx, q.limiter.key(q.tenant, "requests"), lim.rps, lim.burst, -q.requests, true)
+	}
+	if q.tokens > 0 {
+		q.limiter.take(ctx, q.limiter.key(q.tenant, "tokens"), lim.tpm/60, lim.tpm, -q.tokens, true)
+	}
+	q.requests, q.tokens = 0, 0
+}
+
+// take runs a Take, admitting the request if the store fails so an outage
+// of a shared store does not take the router down with it.
+func (l *rateLimiter) take(ctx context.Context, key string, rate, burst, n float64, force bool) bucketState {
+	st, err := l.store.Take(ctx, key, rate, burst, n, force)
+	if err != nil {
+		log.Printf("rate limit store failed, allowing request: %v", err)
+		return bucketState{allowed: true, remaining: burst}
+	}
+	return st
+}
+
+func (l *rateLimiter) limited(w http.ResponseWriter, quota string, retryAfter time.Duration) {
+	if l.onLimited != nil {
+		l.onLimited(quota)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	http.Error(w, "rate limit exceeded: too many "+quota, http.StatusTooManyRequests)
+}
+
+// chargeCompletion charges tokens generated for the request behind ctx to
+// its tenant's token quota. It is a no-op for requests without a quota.
+func chargeCompletion(ctx context.Context, tokens int) {
+	q, ok := ctx.Value(quotaKey{}).(*quota)
+	if !ok || tokens <= 0 {
+		return
+	}
+	lim := q.limiter.limitFor(q.tenant)
+	if lim.tpm <= 0 {
+		return
+	}
+	// Charge even if the client has gone away; the tokens were spent.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second)
+	defer cancel()
+	q.limiter.take(ctx, q.limiter.key(q.tenant, "tokens"), lim.tpm/60, lim.tpm, float64(tokens), true)
+}
+
+func formatQuota(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// localBuckets is an in-process bucketStore; each router pod enforces its
+// own share of the quota.
+type localBuckets struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+// maxLocalBuckets bounds localBuckets; beyond it, full buckets are dropped,
+// which loses nothing since a missing bucket starts full.
+const maxLocalBuckets = 10000
+
+func newLocalBuckets() *localBuckets {
+	return &localBuckets{now: time.Now, buckets: map[string]*bucket{}}
+}
+
+func (s *localBuckets) Take(_ context.Context, key string, rate, burst, n float64, force bool) (bucketState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxLocalBuckets {
+			s.sweepLocked(now)
+		}
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last, b.rate, b.burst = now, rate, burst
+
+	if force || b.tokens >= n {
+		// A negative n, a refund, cannot overfill the bucket.
+		b.tokens = min(burst, b.tokens-n)
+		return bucketState{allowed: true, remaining: b.tokens}, nil
+	}
+	wait := time.Duration((n - b.tokens) / rate * float64(time.Second))
+	return bucketState{remaining: b.tokens, retryAfter: wait}, nil
+}
+
+// sweepLocked drops buckets that have refilled.
+func (s *localBuckets) sweepLocked(now time.Time) {
+	for k, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst {
+			delete(s.buckets, k)
+		}
+	}
+}
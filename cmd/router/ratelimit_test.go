@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalBucketsRefillAndDebt(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newLocalBuckets()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := range 2 {
+		if st, _ := s.Take(ctx, "k", 1, 2, 1, false); !st.allowed {
+			t.Fatalf("take %d refused within burst", i)
+		}
+	}
+	st, _ := s.Take(ctx, "k", 1, 2, 1, false)
+	if st.allowed || st.retryAfter != time.Second {
+		t.Fatalf("take beyond burst = %+v, want refused with 1s retry", st)
+	}
+
+	now = now.Add(time.Second)
+	if st, _ := s.Take(ctx, "k", 1, 2, 1, false); !st.allowed {
+		t.Fatal("take refused after refill")
+	}
+
+	if st, _ := s.Take(ctx, "k", 1, 2, 3, true); !st.allowed || st.remaining != -3 {
+		t.Fatalf("forced take = %+v, want allowed with -3 remaining", st)
+	}
+}
+
+func TestRouterRateLimitsRequestsPerTenant(t *testing.T) {
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.limits = &rateLimiter{defaults: rateLimit{rps: 1, burst: 2}, store: newLocalBuckets()}
+
+	post := func(tenant string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/infer", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set(tenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		rt.routes().ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	for i := range 2 {
+		if resp := post("a"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d status = %d", i, resp.StatusCode)
+		}
+	}
+	resp := post("a")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" ||
+		resp.Header.Get("X-RateLimit-Limit-Requests") != "1" ||
+		resp.Header.Get("X-RateLimit-Remaining-Requests") != "0" {
+		t.Fatalf("rate limit headers = %v", resp.Header)
+	}
+
+	if resp := post("b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("other tenant status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRouterRateLimitsTokens(t *testing.T) {
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.limits = &rateLimiter{defaults: rateLimit{tpm: 10}, store: newLocalBuckets()}
+
+	// The body is 5 estimated tokens and the completion 2 more.
+	body := `{"prompt":"hello"}`
+	rec := postJSON(t, rt, "/infer", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining-Tokens"); got != "5" {
+		t.Fatalf("remaining tokens = %q, want 5", got)
+	}
+
+	rec = postJSON(t, rt, "/infer", body)
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "tokens") {
+		t.Fatalf("status = %d, body = %q; want 429 for tokens", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouterRejectsBodyTooLargeToEstimate(t *testing.T) {
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.limits = &rateLimiter{defaults: rateLimit{rps: 1, tpm: 1e6}, store: newLocalBuckets(), maxBody: 64}
+
+	rec := postJSON(t, rt, "/infer", `{"prompt":"`+strings.Repeat("x", 100)+`"}`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, body = %q; want 413", rec.Code, rec.Body.String())
+	}
+	// The rejected request gave its request quota back.
+	if rec := postJSON(t, rt, "/infer", `{"prompt":"hello"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q; want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRouterRefundsRequestsShedByAdmission(t *testing.T) {
+	rt := newTestRouter(fakeBackend(t).URL)
+	rt.admission = newAdmissionQueue(1, 0, time.Second)
+	rt.limits = &rateLimiter{defaults: rateLimit{rps: 0.001, burst: 1}, store: newLocalBuckets()}
+
+	release, err := rt.admission.Acquire(context.Background(), requestClass{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := postJSON(t, rt, "/infer", `{"prompt":"hi"}`)
+	if rec.Code != http.StatusTooManyRequests || strings.Contains(rec.Body.String(), "rate limit") {
+		t.Fatalf("status = %d, body = %q; want 429 from the full queue", rec.Code, rec.Body.String())
+	}
+	release()
+
+	// The shed request's token was given back, so the burst of one is
+	// still there.
+	if rec := postJSON(t, rt, "/infer", `{"prompt":"hi"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q; want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestParseTenantRateLimits(t *testing.T) {
+	got, err := parseTenantRateLimits("chat=10:20:60000, batch=0.5:1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["chat"] != (rateLimit{rps: 10, burst: 20, tpm: 60000}) || got["batch"] != (rateLimit{rps: 0.5, burst: 1}) {
+		t.Fatalf("parsed %+v", got)
+	}
+	for _, bad := range []string{"chat", "chat=1:2", "=1:2:3", "chat=1:x:3", "chat=-1:1:1"} {
+		if _, err := parseTenantRateLimits(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestRedisBucketsTake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	cmds := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		rc := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+		v, err := rc.readReply()
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, a := range v.([]any) {
+			args = append(args, a.(string))
+		}
+		cmds <- args
+		_, _ = conn.Write([]byte("*3\r\n:0\r\n:1500\r\n:2500\r\n"))
+	}()
+
+	s := newRedisBuckets(func(string) string { return ln.Addr().String() }, newLocalBuckets())
+	st, err := s.Take(context.Background(), "ratelimit:svc:a:requests", 2, 4, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.allowed || st.remaining != 1.5 || st.retryAfter != 2500*time.Millisecond {
+		t.Fatalf("state = %+v", st)
+	}
+	args := <-cmds
+	if len(args) != 8 || args[0] != "EVAL" || args[3] != "ratelimit:svc:a:requests" || args[4] != "2" || args[5] != "4" {
+		t.Fatalf("command = %q", args)
+	}
+}
+
+func TestRedisBucketsFallBackWithoutNode(t *testing.T) {
+	s := newRedisBuckets(func(string) string { return "" }, newLocalBuckets())
+	st, err := s.Take(context.Background(), "k", 1, 1, 1, false)
+	if err != nil || !st.allowed {
+		t.Fatalf("Take = %+v, %v; want allowed by the local fallback", st, err)
+	}
+}
+
+func TestRedisBucketsSkipRedisWhileFailing(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	lookups := 0
+	s := newRedisBuckets(func(string) string { lookups++; return addr }, newLocalBuckets())
+	now := time.Unix(0, 0)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for range 3 {
+		if st, err := s.Take(ctx, "k", 1, 10, 1, false); err != nil || !st.allowed {
+			t.Fatalf("Take = %+v, %v; want allowed by the local fallback", st, err)
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("tried Redis %d times while it was down, want 1", lookups)
+	}
+
+	now = now.Add(s.retryAfter)
+	_, _ = s.Take(ctx, "k", 1, 10, 1, false)
+	if lookups != 2 {
+		t.Fatalf("tried Redis %d times after retryAfter, want 2", lookups)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// takeScript is the token bucket of localBuckets.Take, run atomically on a
+// Redis node so every router replica draws from the same bucket. Remaining
+// tokens come back in thousandths, since Redis truncates Lua numbers to
+// integers.
+const takeScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local force = ARGV[4] == "1"
+local t = redis.call("TIME")
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+local b = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+local allowed = 0
+local retry = 0
+if force or tokens >= n then
+  tokens = math.min(burst, tokens - n)
+  allowed = 1
+else
+  retry = math.ceil((n - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, math.floor(tokens * 1000), retry}
+`
+
+// redisBuckets is a bucketStore shared by all router replicas, kept in the
+// KVCachePool's Redis nodes. Each bucket lives on the node the KV hash ring
+// assigns to its key. After Redis fails, the pod uses its own local buckets
+// for retryAfter before trying Redis again, so an outage does not add a
+// timeout to every request.
+//
+// The pool evicts with an allkeys-* policy, so under memory pressure Redis
+// may drop a bucket, which then starts over full.
+type redisBuckets struct {
+	// node returns the address of the Redis node for key, or "" if none
+	// is known.
+	node       func(key string) string
+	timeout    time.Duration
+	retryAfter time.Duration
+	fallback   bucketStore
+	now        func() time.Time
+	// failingUntil is when, in Unix nanoseconds, Redis is next tried
+	// after a failure. It is zero while Redis works.
+	failingUntil atomic.Int64
+
+	mu   sync.Mutex
+	idle map[string][]*redisConn
+}
+
+func newRedisBuckets(node func(key string) string, fallback bucketStore) *redisBuckets {
+	return &redisBuckets{
+		node:       node,
+		timeout:    200 * time.Millisecond,
+		retryAfter: 5 * time.Second,
+		fallback:   fallback,
+		now:        time.Now,
+		idle:       map[string][]*redisConn{},
+	}
+}
+
+var errNoRedisNode = errors.New("no KV cache node to keep rate limits in")
+
+func (s *redisBuckets) Take(ctx context.Context, key string, rate, burst, n float64, force bool) (bucketState, error) {
+	if until := s.failingUntil.Load(); until != 0 && s.now().UnixNano() < until {
+		return s.fallback.Take(ctx, key, rate, burst, n, force)
+	}
+	st, err := s.take(ctx, key, rate, burst, n, force)
+	if err != nil {
+		if s.failingUntil.Swap(s.now().Add(s.retryAfter).UnixNano()) == 0 {
+			log.Printf("shared rate limits unavailable, enforcing them per pod: %v", err)
+		}
+		return s.fallback.Take(ctx, key, rate, burst, n, force)
+	}
+	if s.failingUntil.Swap(0) != 0 {
+		log.Printf("shared rate limits available again")
+	}
+	return st, nil
+}
+
+func (s *redisBuckets) take(ctx context.Context, key string, rate, burst, n float64, force bool) (bucketState, error) {
+	addr := s.node(key)
+	if addr == "" {
+		return bucketState{}, errNoRedisNode
+	}
+	forceArg := "0"
+	if force {
+		forceArg = "1"
+	}
+	reply, err := s.do(ctx, addr, "EVAL", takeScript, "1", key,
+		formatQuota(rate), formatQuota(burst), formatQuota(n), forceArg)
+	if err != nil {
+		return bucketState{}, err
+	}
+	vals, ok := reply.([]any)
+	if !ok || len(vals) != 3 {
+		return bucketState{}, fmt.Errorf("unexpected rate limit reply from %s: %v", addr, reply)
+	}
+	var ints [3]int64
+	for i, v := range vals {
+		if ints[i], ok = v.(int64); !ok {
+			return bucketState{}, fmt.Errorf("unexpected rate limit reply from %s: %v", addr, reply)
+		}
+	}
+	return bucketState{
+		allowed:    ints[0] == 1,
+		remaining:  float64(ints[1]) / 1000,
+		retryAfter: time.Duration(ints[2]) * time.Millisecond,
+	}, nil
+}
+
+// do sends one command to addr over a pooled connection and returns the
+// decoded reply.
+func (s *redisBuckets) do(ctx context.Context, addr string, args ...string) (any, error) {
+	conn, err := s.get(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	reply, err := conn.do(args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection may be mid-reply; do not reuse it.
+		_ = conn.Close()
+		return nil, fmt.Errorf("redis %s: %w", addr, err)
+	}
+	s.put(addr, conn)
+	if err != nil {
+		return nil, fmt.Errorf("redis %s: %w", addr, err)
+	}
+	return reply, nil
+}
+
+func (s *redisBuckets) get(ctx context.Context, addr string) (*redisConn, error) {
+	s.mu.Lock()
+	if conns := s.idle[addr]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		s.idle[addr] = conns[:len(conns)-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	d := net.Dialer{Timeout: s.timeout}
+	c, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis %s: %w", addr, err)
+	}
+	return &redisConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// maxIdleRedisConns caps the idle connections kept per node.
+const maxIdleRedisConns = 8
+
+func (s *redisBuckets) put(addr string, c *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle[addr]) >= maxIdleRedisConns {
+		_ = c.Close()
+		return
+	}
+	s.idle[addr] = append(s.idle[addr], c)
+}
+
+// redisConn speaks just enough RESP for the rate limiter: commands as
+// arrays of bulk strings, and integer, string, error and array replies.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// redisError is an error reply; the connection stays usable.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+func (c *redisConn) do(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := c.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		out := make([]any, n)
+		for i := range out {
+			v, err := c.readReply()
+			var redisErr redisError
+			if errors.As(err, &redisErr) {
+				// Keep reading so the connection stays in step.
+				v, err = redisErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unexpected redis reply %q", line)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// sseDone is the payload of the terminal event of a stream, as used by the
+// OpenAI streaming API.
+const sseDone = "[DONE]"
+
+var errStreamingUnsupported = errors.New("response writer does not support flushing")
+
+// readSSE reads a text/event-stream body and calls onData with the payload
+// of every "data:" line until the stream ends or a [DONE] event arrives.
+func readSSE(r io.Reader, onData func([]byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if string(data) == sseDone {
+			return nil
+		}
+		if err := onData(data); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// sseWriter streams server-sent events to a client. Headers are only sent
+// with the first event, so a request that fails before producing anything
+// can still be answered with a regular HTTP error.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errStreamingUnsupported
+	}
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// Started reports whether any event has been written yet.
+func (s *sseWriter) Started() bool {
+	return s.started
+}
+
+// Event writes v as a JSON data event and flushes it to the client.
+func (s *sseWriter) Event(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// Done writes the terminal [DONE] event.
+func (s *sseWriter) Done() error {
+	return s.write([]byte(sseDone))
+}
+
+func (s *sseWriter) write(data []byte) error {
+	if !s.started {
+		h := s.w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		s.w.WriteHeader(http.StatusOK)
+		s.started = true
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamingBackend streams each word of the prompt as its own chunk.
+func streamingBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			http.Error(w, "expected a streaming request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		words := strings.Fields(req.Prompt)
+		for i, word := range words {
+			chunk := GenerateChunk{Text: word}
+			if i == len(words)-1 {
+				chunk.FinishReason = "stop"
+			}
+			b, _ := json.Marshal(chunk)
+			_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
+			w.(http.Flusher).Flush()
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// sseEvents returns the data payloads of a recorded event stream.
+func sseEvents(t *testing.T, body io.Reader) []string {
+	t.Helper()
+	var events []string
+	if err := readSSE(body, func(data []byte) error {
+		events = append(events, string(data))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestInferStream(t *testing.T) {
+	rec := postJSON(t, newTestRouter(streamingBackend(t).URL), "/infer",
+		`{"prompt":"one two three","stream":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n") {
+		t.Fatalf("stream does not end with [DONE]: %q", rec.Body.String())
+	}
+
+	events := sseEvents(t, rec.Body)
+	want := []string{
+		`{"text":"one"}`,
+		`{"text":"two"}`,
+		`{"text":"three","finishReason":"stop"}`,
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("events = %q, want %q", events, want)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	rec := postJSON(t, newTestRouter(streamingBackend(t).URL), "/v1/chat/completions",
+		`{"messages":[{"role":"user","content":"hi"}],"stream":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+
+	events := sseEvents(t, rec.Body)
+	// The rendered prompt is "user: hi\nassistant:", i.e. three words.
+	if len(events) != 3 {
+		t.Fatalf("got %d events: %q", len(events), events)
+	}
+	var chunks []ChatCompletionChunk
+	for _, e := range events {
+		var c ChatCompletionChunk
+		if err := json.Unmarshal([]byte(e), &c); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c)
+	}
+	if chunks[0].Object != "chat.completion.chunk" || chunks[0].Choices[0].Delta.Role != "assistant" {
+		t.Fatalf("first chunk = %+v", chunks[0])
+	}
+	if chunks[1].Choices[0].Delta.Role != "" || chunks[1].Choices[0].FinishReason != nil {
+		t.Fatalf("middle chunk = %+v", chunks[1])
+	}
+	if fr := chunks[2].Choices[0].FinishReason; fr == nil || *fr != "stop" {
+		t.Fatalf("last chunk = %+v", chunks[2])
+	}
+	if chunks[0].ID != chunks[2].ID {
+		t.Fatalf("chunk IDs differ: %q vs %q", chunks[0].ID, chunks[2].ID)
+	}
+}
+
+func TestStreamUpstreamErrorBeforeFirstChunk(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	rec := postJSON(t, newTestRouter(backend.URL), "/infer", `{"prompt":"hi","stream":true}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStreamClientDisconnectCancelsBackend(t *testing.T) {
+	backendDone := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(backendDone)
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for {
+			if _, err := fmt.Fprint(w, "data: {\"text\":\"tok\"}\n\n"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer backend.Close()
+
+	rt := newTestRouter(backend.URL)
+	srv := httptest.NewServer(rt.routes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/infer",
+		strings.NewReader(`{"prompt":"hi","stream":true}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	if _, err := resp.Body.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	_ = resp.Body.Close()
+
+	select {
+	case <-backendDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend request was not cancelled after the client disconnected")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// batchConfig is set on the pods of a batch LLMInferenceJob. Each pod of the
+// indexed Job is one shard.
+type batchConfig struct {
+	input  string
+	output string
+	shards int
+	index  int
+}
+
+// batchResult is one line of a shard's results file.
+type batchResult struct {
+	Line   int    `json:"line"`
+	Prompt string `json:"prompt"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchSummary is the shard's termination message, which the operator
+// copies into the job's status.
+type batchSummary struct {
+	Prompts int    `json:"prompts"`
+	Errors  int    `json:"errors"`
+	Output  string `json:"output"`
+}
+
+func loadBatchConfig() (batchConfig, bool, error) {
+	cfg := batchConfig{
+		input:  os.Getenv("BATCH_INPUT"),
+		output: os.Getenv("BATCH_OUTPUT"),
+	}
+	if cfg.input == "" {
+		return cfg, false, nil
+	}
+	if cfg.output == "" {
+		return cfg, true, errors.New("BATCH_OUTPUT is not set")
+	}
+
+	var err error
+	if cfg.shards, err = strconv.Atoi(getenv("BATCH_SHARDS", "1")); err != nil || cfg.shards < 1 {
+		return cfg, true, fmt.Errorf("invalid BATCH_SHARDS=%q", os.Getenv("BATCH_SHARDS"))
+	}
+	// Set by the Job controller on every pod of an indexed Job.
+	cfg.index, err = strconv.Atoi(getenv("JOB_COMPLETION_INDEX", "0"))
+	if err != nil || cfg.index < 0 || cfg.index >= cfg.shards {
+		return cfg, true, fmt.Errorf("invalid JOB_COMPLETION_INDEX=%q", os.Getenv("JOB_COMPLETION_INDEX"))
+	}
+	return cfg, true, nil
+}
+
+// runBatch completes every prompt in the shard's slice of the input and
+// writes one result per line to <output>/shard-<index>.jsonl. A prompt that
+// fails is recorded in its result line; only I/O errors fail the shard.
+func runBatch(ctx context.Context, client *http.Client, cfg config, batch batchConfig) (batchSummary, error) {
+	in, err := os.Open(batch.input)
+	if err != nil {
+		return batchSummary{}, err
+	}
+	defer func() { _ = in.Close() }()
+
+	if err := os.MkdirAll(batch.output, 0o755); err != nil {
+		return batchSummary{}, err
+	}
+	path := filepath.Join(batch.output, fmt.Sprintf("shard-%d.jsonl", batch.index))
+	// Write to a temporary file so a retried shard never leaves a partial
+	// results file behind.
+	tmp, err := os.CreateTemp(batch.output, fmt.Sprintf(".shard-%d-*.jsonl", batch.index))
+	if err != nil {
+		return batchSummary{}, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	summary := batchSummary{Output: path}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		n := line
+		line++
+		if n%batch.shards != batch.index {
+			continue
+		}
+
+		res := batchResult{Line: n}
+		prompt, err := parsePromptLine(text)
+		if err == nil {
+			res.Prompt = prompt
+			one := cfg
+			one.prompt = prompt
+			res.Output, err = complete(ctx, client, one)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return summary, ctx.Err()
+			}
+			res.Error = err.Error()
+			summary.Errors++
+		}
+		summary.Prompts++
+		if err := enc.Encode(res); err != nil {
+			return summary, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return summary, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return summary, err
+	}
+	if err := tmp.Close(); err != nil {
+		return summary, err
+	}
+	return summary, os.Rename(tmp.Name(), path)
+}
+
+// parsePromptLine accepts either {"prompt": "..."} or a bare JSON string.
+func parsePromptLine(line string) (string, error) {
+	var obj struct {
+		Prompt *string `json:"prompt"`
+	}
+	if err := json.Unmarshal([]byte(line), &obj); err == nil && obj.Prompt != nil {
+		return *obj.Prompt, nil
+	}
+	var s string
+	if err := json.Unmarshal([]byte(line), &s); err == nil {
+		return s, nil
+	}
+	return "", errors.New(`line is neither {"prompt": ...} nor a JSON string`)
+}
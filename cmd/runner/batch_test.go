@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunBatchProcessesOwnShard(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req completionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Prompt == "fail" {
+			http.Error(w, "backend down", http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]string{{"text": strings.ToUpper(req.Prompt)}},
+		})
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "prompts.jsonl")
+	lines := `{"prompt":"a"}
+"b"
+
+{"prompt":"c"}
+"fail"
+"d"
+not json
+`
+	if err := os.WriteFile(input, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config{endpoint: srv.URL, timeout: time.Second}
+	out := filepath.Join(dir, "results")
+	summary, err := runBatch(context.Background(), srv.Client(), cfg, batchConfig{
+		input: input, output: out, shards: 2, index: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shard 1 of 2 takes prompts 1 ("b"), 3 ("fail") and 5 (not json);
+	// blank lines are not counted.
+	if summary.Prompts != 3 || summary.Errors != 2 || summary.Output != filepath.Join(out, "shard-1.jsonl") {
+		t.Fatalf("summary = %+v", summary)
+	}
+
+	data, err := os.ReadFile(summary.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []batchResult
+	for _, l := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r batchResult
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 3 || results[0].Output != "B" || results[1].Error == "" || results[2].Line != 5 {
+		t.Fatalf("results = %+v", results)
+	}
+
+	entries, _ := os.ReadDir(out)
+	if len(entries) != 1 {
+		t.Fatalf("output dir has %d entries, want only the results file", len(entries))
+	}
+}
@@ -0,0 +1,208 @@
+// Command runner executes an LLMInferenceJob: it sends the job's prompt to an
+// InferenceService router's OpenAI-compatible completions endpoint, prints
+// the generated text and leaves it in the container's termination message for
+// the operator to copy into the job's status. In batch mode each pod of the
+// indexed Job completes one shard of a JSONL file of prompts instead.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// config is read from the environment the operator sets on the runner pod.
+type config struct {
+	endpoint  string
+	model     string
+	prompt    string
+	maxTokens int
+	timeout   time.Duration
+	// apiKey is presented to routers that require one.
+	apiKey string
+}
+
+type completionRequest struct {
+	Model     string `json:"model,omitempty"`
+	Prompt    string `json:"prompt"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+}
+
+type completionResponse struct {
+	Choices []struct {
+		Text string `json:"text"`
+	} `json:"choices"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func main() {
+	terminationLog := getenv("TERMINATION_MESSAGE_PATH", "/dev/termination-log")
+
+	out, err := run()
+	os.Exit(finish(os.Stdout, os.Stderr, terminationLog, out, err))
+}
+
+// finish reports the outcome of run and returns the exit code. On success
+// the output is the only thing written, to stdout: the operator reads a
+// generation too long for the termination message back from the pod's
+// logs, which may mix in stderr.
+func finish(stdout, stderr io.Writer, terminationLog, out string, err error) int {
+	if err == nil {
+		_, _ = fmt.Fprintln(stdout, out)
+		// Without a termination message the operator takes the
+		// output from the logs instead.
+		_ = writeTerminationMessage(terminationLog, out)
+		return 0
+	}
+
+	logger := log.New(stderr, "", log.LstdFlags)
+	logger.Printf("runner failed: %v", err)
+	if err := writeTerminationMessage(terminationLog, err.Error()); err != nil {
+		logger.Printf("writing termination message: %v", err)
+	}
+	return 1
+}
+
+// run executes the job and returns its termination message: the generated
+// text for a single prompt, or a JSON batchSummary for a batch shard.
+func run() (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	batch, isBatch, err := loadBatchConfig()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if !isBatch {
+		return complete(ctx, http.DefaultClient, cfg)
+	}
+	summary, err := runBatch(ctx, http.DefaultClient, cfg, batch)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("shard %d/%d: %d prompts, %d errors, results in %s",
+		batch.index, batch.shards, summary.Prompts, summary.Errors, summary.Output)
+	data, err := json.Marshal(summary)
+	return string(data), err
+}
+
+func loadConfig() (config, error) {
+	cfg := config{
+		endpoint: strings.TrimRight(os.Getenv("ENDPOINT"), "/"),
+		model:    os.Getenv("MODEL"),
+		prompt:   os.Getenv("PROMPT"),
+		apiKey:   os.Getenv("API_KEY"),
+		timeout:  10 * time.Minute,
+	}
+	if cfg.endpoint == "" {
+		return cfg, errors.New("ENDPOINT is not set")
+	}
+	// A prompt from a ConfigMap or Secret is mounted as a file.
+	if path := os.Getenv("PROMPT_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading PROMPT_FILE: %w", err)
+		}
+		cfg.prompt = string(data)
+	}
+	if v := os.Getenv("MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid MAX_TOKENS=%q", v)
+		}
+		cfg.maxTokens = n
+	}
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid REQUEST_TIMEOUT=%q", v)
+		}
+		cfg.timeout = d
+	}
+	return cfg, nil
+}
+
+// complete sends the prompt to cfg.endpoint and returns the generated text.
+func complete(ctx context.Context, client *http.Client, cfg config) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	body, err := json.Marshal(completionRequest{
+		Model:     cfg.model,
+		Prompt:    cfg.prompt,
+		MaxTokens: cfg.maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.endpoint+"/v1/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			return "", fmt.Errorf("%s: %s", resp.Status, e.Error.Message)
+		}
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var out completionResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", fmt.Errorf("decoding completion: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("completion has no choices")
+	}
+	return out.Choices[0].Text, nil
+}
+
+// writeTerminationMessage leaves msg where the kubelet picks it up as the
+// container's termination message. Failing to write it is not fatal: the
+// output is on stdout as well.
+func writeTerminationMessage(path, msg string) error {
+	return os.WriteFile(path, []byte(msg), 0o644)
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
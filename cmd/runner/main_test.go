@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompleteReturnsGeneratedText(t *testing.T) {
+	var got completionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"choices":[{"text":"generated"}]}`))
+	}))
+	defer srv.Close()
+
+	out, err := complete(context.Background(), srv.Client(), config{
+		endpoint:  srv.URL,
+		model:     "m",
+		prompt:    "it's a prompt; $(rm -rf /)",
+		maxTokens: 8,
+		timeout:   time.Second,
+		apiKey:    "secret-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "generated" {
+		t.Fatalf("output = %q", out)
+	}
+	if got.Prompt != "it's a prompt; $(rm -rf /)" || got.Model != "m" || got.MaxTokens != 8 {
+		t.Fatalf("request = %+v", got)
+	}
+}
+
+func TestCompleteReportsRouterErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"message":"model \"x\" is not served here"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := complete(context.Background(), srv.Client(), config{endpoint: srv.URL, timeout: time.Second})
+	if err == nil || !strings.Contains(err.Error(), `model "x" is not served here`) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLoadConfigReadsPromptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt")
+	prompt := "line one\nit's \"quoted\" `and` $(not run)\n"
+	if err := os.WriteFile(path, []byte(prompt), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENDPOINT", "http://router/")
+	t.Setenv("PROMPT", "ignored")
+	t.Setenv("PROMPT_FILE", path)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.prompt != prompt || cfg.endpoint != "http://router" {
+		t.Fatalf("config = %+v", cfg)
+	}
+}
+
+func TestFinishWritesOnlyTheOutputOnSuccess(t *testing.T) {
+	long := strings.Repeat("generated ", 1000)
+	// A termination message that cannot be written must not put a
+	// diagnostic next to the output the operator reads from the logs.
+	unwritable := filepath.Join(t.TempDir(), "missing", "termination-log")
+
+	var stdout, stderr bytes.Buffer
+	if code := finish(&stdout, &stderr, unwritable, long, nil); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if stdout.String() != long+"\n" || stderr.Len() != 0 {
+		t.Fatalf("stdout has %d bytes, stderr = %q; want only the output on stdout", stdout.Len(), stderr.String())
+	}
+
+	stdout.Reset()
+	if code := finish(&stdout, &stderr, unwritable, "", errors.New("router said no")); code != 1 {
+		t.Fatalf("exit code = %d, want 1", code)
+	}
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "router said no") ||
+		!strings.Contains(stderr.String(), "writing termination message") {
+		t.Fatalf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+}
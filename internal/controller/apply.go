@@ -0,0 +1,85 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// fieldManager is the server-side apply field manager for every object the
+// operator owns.
+const fieldManager = "llama-shepherd"
+
+// applyOwned server-side applies desired, a fully built object, as a child of
+// owner and decodes the live result back into desired.
+//
+// The manager owns exactly the fields desired sets: they are forced back on
+// every pass, so spec changes roll out and edits to them are reverted, while
+// fields it never sets (API server defaults, other controllers' additions)
+// are left alone. Status is never applied.
+func applyOwned(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner, desired client.Object) error {
+	if err := ctrl.SetControllerReference(owner, desired, scheme); err != nil {
+		return err
+	}
+	gvk, err := apiutil.GVKForObject(desired, scheme)
+	if err != nil {
+		return err
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return err
+	}
+	// Typed structs serialize zero values such as creationTimestamp: null
+	// and an empty status; applying them would claim fields this manager
+	// does not mean to set.
+	delete(content, "status")
+	pruneNulls(content)
+
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	ac := client.ApplyConfigurationFromUnstructured(u)
+	if err := c.Apply(ctx, ac, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, desired)
+}
+
+// pruneNulls removes nil values from m, recursing into nested maps and
+// lists.
+func pruneNulls(m map[string]any) {
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+			delete(m, k)
+		case map[string]any:
+			pruneNulls(v)
+		case []any:
+			for _, item := range v {
+				if im, ok := item.(map[string]any); ok {
+					pruneNulls(im)
+				}
+			}
+		}
+	}
+}
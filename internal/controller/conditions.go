@@ -0,0 +1,113 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+// statusConditions sets the Ready, Progressing and Degraded conditions on a
+// resource at one generation.
+type statusConditions struct {
+	conditions *[]metav1.Condition
+	generation int64
+}
+
+func (s statusConditions) set(condType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(s.conditions, metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: s.generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// failed marks the resource as not ready and degraded for reason.
+func (s statusConditions) failed(reason, message string) {
+	s.set(llmv1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+	s.set(llmv1alpha1.ConditionProgressing, metav1.ConditionFalse, reason, message)
+	s.set(llmv1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+}
+
+// rollout summarises an owned workload for the status conditions.
+type rollout struct {
+	desired, ready int32
+	// inProgress is true until the workload controller has observed the
+	// latest spec and replaced every old pod.
+	inProgress bool
+	// stalled explains why the rollout stopped making progress, if it did.
+	stalled string
+}
+
+func deploymentRollout(d *appsv1.Deployment, desired int32) rollout {
+	ro := rollout{
+		desired: desired,
+		ready:   d.Status.AvailableReplicas,
+		inProgress: d.Status.ObservedGeneration < d.Generation ||
+			d.Status.UpdatedReplicas < desired ||
+			d.Status.Replicas > d.Status.UpdatedReplicas ||
+			d.Status.AvailableReplicas < d.Status.UpdatedReplicas,
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status != corev1.ConditionTrue &&
+			c.Reason == "ProgressDeadlineExceeded" {
+			ro.stalled = c.Message
+		}
+	}
+	return ro
+}
+
+func statefulSetRollout(s *appsv1.StatefulSet, desired int32) rollout {
+	return rollout{
+		desired: desired,
+		ready:   s.Status.ReadyReplicas,
+		inProgress: s.Status.ObservedGeneration < s.Generation ||
+			s.Status.UpdateRevision != s.Status.CurrentRevision ||
+			s.Status.ReadyReplicas < desired,
+	}
+}
+
+// setRollout records ro on s. noun names the pods in messages and
+// unavailableReason is used for Ready while too few of them are ready.
+func (s statusConditions) setRollout(ro rollout, noun, unavailableReason string) {
+	msg := fmt.Sprintf("%d/%d %s ready", ro.ready, ro.desired, noun)
+	if ro.ready >= ro.desired {
+		s.set(llmv1alpha1.ConditionReady, metav1.ConditionTrue, llmv1alpha1.ReasonAvailable, msg)
+	} else {
+		s.set(llmv1alpha1.ConditionReady, metav1.ConditionFalse, unavailableReason, msg)
+	}
+
+	if ro.inProgress {
+		s.set(llmv1alpha1.ConditionProgressing, metav1.ConditionTrue, llmv1alpha1.ReasonRolloutInProgress, msg)
+	} else {
+		s.set(llmv1alpha1.ConditionProgressing, metav1.ConditionFalse, llmv1alpha1.ReasonRolloutComplete, msg)
+	}
+
+	if ro.stalled != "" {
+		s.set(llmv1alpha1.ConditionDegraded, metav1.ConditionTrue, llmv1alpha1.ReasonRolloutStalled, ro.stalled)
+	} else {
+		s.set(llmv1alpha1.ConditionDegraded, metav1.ConditionFalse, llmv1alpha1.ReasonAsExpected, "")
+	}
+}
@@ -21,21 +21,32 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
 )
 
+const (
+	componentLabel = "app.kubernetes.io/component"
+	// routerComponent labels router pods and Services so the ServiceMonitor
+	// in config/prometheus can select every InferenceService at once.
+	routerComponent = "inference-router"
+)
+
 // InferenceServiceReconciler reconciles a InferenceService object
 type InferenceServiceReconciler struct {
 	client.Client
@@ -48,6 +59,7 @@ type InferenceServiceReconciler struct {
 // +kubebuilder:rbac:groups=llm.example.com,resources=inferenceservices/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=llm.example.com,resources=kvcachepools,verbs=get;list;watch
 
 func (r *InferenceServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
@@ -65,16 +77,33 @@ func (r *InferenceServiceReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	deployName := isvc.Name + "-router"
 	svcName := isvc.Name
 
+	orig := isvc.Status.DeepCopy()
+	isvc.Status.ObservedGeneration = isvc.Generation
+	conds := statusConditions{conditions: &isvc.Status.Conditions, generation: isvc.Generation}
+
 	var kvpool llmv1alpha1.KVCachePool
 	if isvc.Spec.CachePoolRef != "" {
 		if err := r.Get(ctx, client.ObjectKey{
 			Name:      isvc.Spec.CachePoolRef,
 			Namespace: isvc.Namespace,
 		}, &kvpool); err != nil {
+			if !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
 			log.Error(err, "failed to find referenced KVCachePool")
-			// Do NOT return error — instead, requeue later
-			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+			msg := fmt.Sprintf("KVCachePool %q not found", isvc.Spec.CachePoolRef)
+			conds.failed(llmv1alpha1.ReasonCachePoolNotFound, msg)
+			conds.set(llmv1alpha1.ConditionCachePoolReady, metav1.ConditionFalse, llmv1alpha1.ReasonCachePoolNotFound, msg)
+			if err := r.updateStatus(ctx, &isvc, orig); err != nil {
+				return ctrl.Result{}, err
+			}
+			// No requeue: the KVCachePool watch brings us back once the
+			// pool is created.
+			return ctrl.Result{}, nil
 		}
+		setCachePoolReady(conds, &kvpool)
+	} else {
+		meta.RemoveStatusCondition(&isvc.Status.Conditions, llmv1alpha1.ConditionCachePoolReady)
 	}
 
 	cacheEndpoints := []string{}
@@ -89,59 +118,241 @@ func (r *InferenceServiceReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		)
 	}
 
-	var deploy appsv1.Deployment
-	err := r.Get(ctx, client.ObjectKey{Name: deployName, Namespace: isvc.Namespace}, &deploy)
-	if err != nil && errors.IsNotFound(err) {
-		// Create a new router Deployment
-		deploy = appsv1.Deployment{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      deployName,
-				Namespace: isvc.Namespace,
-				Labels: map[string]string{
+	// Apply the desired objects on every pass, so spec changes roll out and
+	// manual edits to the fields the operator sets are reverted.
+	deploy := routerDeployment(&isvc, deployName, replicas, cacheEndpoints)
+	if err := applyOwned(ctx, r.Client, r.Scheme, &isvc, deploy); err != nil {
+		log.Error(err, "failed to apply router Deployment", "deployment", deployName)
+		conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+		_ = r.updateStatus(ctx, &isvc, orig)
+		return ctrl.Result{}, err
+	}
+
+	svc := routerService(&isvc, svcName, deployName)
+	if err := applyOwned(ctx, r.Client, r.Scheme, &isvc, svc); err != nil {
+		log.Error(err, "failed to apply router Service", "service", svcName)
+		conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+		_ = r.updateStatus(ctx, &isvc, orig)
+		return ctrl.Result{}, err
+	}
+
+	// Update status with available replicas
+	isvc.Status.AvailableReplicas = deploy.Status.AvailableReplicas
+	conds.setRollout(deploymentRollout(deploy, replicas), "router pods", llmv1alpha1.ReasonDeploymentUnavailable)
+	if err := r.updateStatus(ctx, &isvc, orig); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// setCachePoolReady mirrors pool's Ready condition onto conds.
+func setCachePoolReady(conds statusConditions, pool *llmv1alpha1.KVCachePool) {
+	ready := meta.FindStatusCondition(pool.Status.Conditions, llmv1alpha1.ConditionReady)
+	switch {
+	case ready == nil:
+		conds.set(llmv1alpha1.ConditionCachePoolReady, metav1.ConditionFalse, llmv1alpha1.ReasonCachePoolNotReady,
+			fmt.Sprintf("KVCachePool %q has not reported readiness yet", pool.Name))
+	case ready.Status == metav1.ConditionTrue:
+		conds.set(llmv1alpha1.ConditionCachePoolReady, metav1.ConditionTrue, llmv1alpha1.ReasonAvailable,
+			fmt.Sprintf("KVCachePool %q: %s", pool.Name, ready.Message))
+	default:
+		conds.set(llmv1alpha1.ConditionCachePoolReady, metav1.ConditionFalse, llmv1alpha1.ReasonCachePoolNotReady,
+			fmt.Sprintf("KVCachePool %q: %s", pool.Name, ready.Message))
+	}
+}
+
+// updateStatus writes isvc's status if it differs from orig.
+func (r *InferenceServiceReconciler) updateStatus(ctx context.Context, isvc *llmv1alpha1.InferenceService,
+	orig *llmv1alpha1.InferenceServiceStatus) error {
+	if equality.Semantic.DeepEqual(orig, &isvc.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, isvc); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to update InferenceService status")
+		return err
+	}
+	return nil
+}
+
+// routerDeployment returns the desired router Deployment for isvc.
+func routerDeployment(isvc *llmv1alpha1.InferenceService, deployName string, replicas int32,
+	cacheEndpoints []string) *appsv1.Deployment {
+	maxQueueWait := ""
+	if isvc.Spec.MaxQueueWait != nil {
+		maxQueueWait = isvc.Spec.MaxQueueWait.Duration.String()
+	}
+
+	env := []corev1.EnvVar{
+		{
+			Name:  "MODEL_REF",
+			Value: isvc.Spec.ModelRef,
+		},
+		{
+			Name:  "MAX_CONCURRENCY",
+			Value: strconv.Itoa(int(isvc.Spec.MaxConcurrency)),
+		},
+		{
+			Name:  "MAX_QUEUE_DEPTH",
+			Value: strconv.Itoa(int(isvc.Spec.MaxQueueDepth)),
+		},
+		{
+			Name:  "MAX_QUEUE_WAIT",
+			Value: maxQueueWait,
+		},
+		{
+			Name:  "DRAIN_PERIOD",
+			Value: fmt.Sprintf("%ds", isvc.Spec.DrainPeriodSeconds),
+		},
+		{
+			Name:  "SHUTDOWN_TIMEOUT",
+			Value: fmt.Sprintf("%ds", isvc.Spec.ShutdownTimeoutSeconds),
+		},
+		{
+			Name:  "KV_ENDPOINTS",
+			Value: strings.Join(cacheEndpoints, ","),
+		},
+		{
+			Name:  "BACKEND_ENDPOINTS",
+			Value: strings.Join(isvc.Spec.Backends, ","),
+		},
+	}
+	if sched := isvc.Spec.Scheduling; sched != nil {
+		weights := make([]string, 0, len(sched.Tenants))
+		for _, t := range sched.Tenants {
+			weights = append(weights, fmt.Sprintf("%s=%d", t.Name, t.Weight))
+		}
+		env = append(env,
+			corev1.EnvVar{Name: "SCHED_TIERS", Value: strings.Join(sched.Tiers, ",")},
+			corev1.EnvVar{Name: "SCHED_DEFAULT_TIER", Value: sched.DefaultTier},
+			corev1.EnvVar{Name: "SCHED_TENANT_WEIGHTS", Value: strings.Join(weights, ",")},
+		)
+	}
+	if rl := isvc.Spec.RateLimit; rl != nil {
+		tenants := make([]string, 0, len(rl.Tenants))
+		for _, t := range rl.Tenants {
+			tenants = append(tenants, fmt.Sprintf("%s=%d:%d:%d",
+				t.Name, t.RequestsPerSecond, t.Burst, t.TokensPerMinute))
+		}
+		env = append(env,
+			corev1.EnvVar{Name: "RATE_LIMIT_RPS", Value: strconv.Itoa(int(rl.RequestsPerSecond))},
+			corev1.EnvVar{Name: "RATE_LIMIT_BURST", Value: strconv.Itoa(int(rl.Burst))},
+			corev1.EnvVar{Name: "RATE_LIMIT_TPM", Value: strconv.FormatInt(rl.TokensPerMinute, 10)},
+			corev1.EnvVar{Name: "RATE_LIMIT_TENANTS", Value: strings.Join(tenants, ",")},
+			corev1.EnvVar{Name: "RATE_LIMIT_SHARED", Value: strconv.FormatBool(rl.Shared)},
+			// Keeps InferenceServices sharing a KVCachePool apart.
+			corev1.EnvVar{Name: "RATE_LIMIT_SCOPE", Value: isvc.Namespace + "/" + isvc.Name},
+		)
+	}
+	if b := isvc.Spec.Batching; b != nil {
+		maxWait := ""
+		if b.MaxWait != nil {
+			maxWait = b.MaxWait.Duration.String()
+		}
+		// A batch can never hold more requests than a pod admits at
+		// once.
+		batchSize := b.MaxBatchSize
+		if c := isvc.Spec.MaxConcurrency; c > 0 {
+			batchSize = min(batchSize, c)
+		}
+		env = append(env,
+			corev1.EnvVar{Name: "BATCH_MAX_SIZE", Value: strconv.Itoa(int(batchSize))},
+			corev1.EnvVar{Name: "BATCH_MAX_WAIT", Value: maxWait},
+		)
+	}
+
+	// The pod must outlive the preStop drain plus the router's own
+	// shutdown deadline; the extra seconds cover process exit.
+	gracePeriod := int64(isvc.Spec.DrainPeriodSeconds) + int64(isvc.Spec.ShutdownTimeoutSeconds) + 5
+
+	if isvc.Spec.Auth != nil {
+		env = append(env, corev1.EnvVar{Name: "AUTH_DIR", Value: apiKeysMountPath})
+	}
+	if a := isvc.Spec.Audit; a != nil {
+		var redact []string
+		if a.RedactPrompts {
+			redact = append(redact, "prompts")
+		}
+		if a.RedactOutputs {
+			redact = append(redact, "outputs")
+		}
+		env = append(env,
+			corev1.EnvVar{Name: "AUDIT_DIR", Value: auditMountPath},
+			corev1.EnvVar{Name: "AUDIT_REDACT", Value: strings.Join(redact, ",")},
+			corev1.EnvVar{Name: "AUDIT_REDACT_HASH", Value: strconv.FormatBool(a.HashRedacted)},
+			corev1.EnvVar{Name: "AUDIT_MAX_SIZE_MB", Value: strconv.Itoa(int(a.MaxSizeMB))},
+			corev1.EnvVar{Name: "AUDIT_MAX_FILES", Value: strconv.Itoa(int(a.MaxFiles))},
+		)
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deployName,
+			Namespace: isvc.Namespace,
+			Labels: map[string]string{
+				"app": deployName,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
 					"app": deployName,
 				},
 			},
-			Spec: appsv1.DeploymentSpec{
-				Replicas: ptr.To(replicas),
-				Selector: &metav1.LabelSelector{
-					MatchLabels: map[string]string{
-						"app": deployName,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":          deployName,
+						componentLabel: routerComponent,
 					},
-				},
-				Template: corev1.PodTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{
-						Labels: map[string]string{
-							"app": deployName,
-						},
+					// Lets annotation-based Prometheus setups scrape
+					// the router's /metrics endpoint.
+					Annotations: map[string]string{
+						"prometheus.io/scrape": "true",
+						"prometheus.io/port":   "5678",
+						"prometheus.io/path":   "/metrics",
 					},
-					Spec: corev1.PodSpec{
-						Containers: []corev1.Container{
-							{
-								Name: "router",
-								// For now, use a simple HTTP echo server.
-								// Later, replace with a real Go router image.
-								Image: "ghcr.io/vishalsanfran/llama-shepherd-router:latest",
-								Args: []string{
-									"-text=llama-shepherd router: " + isvc.Spec.ModelRef,
+				},
+				Spec: corev1.PodSpec{
+					TerminationGracePeriodSeconds: ptr.To(gracePeriod),
+					Containers: []corev1.Container{
+						{
+							Name:  "router",
+							Image: "ghcr.io/vishalsanfran/llama-shepherd-router:latest",
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "http",
+									ContainerPort: 5678,
 								},
-								Ports: []corev1.ContainerPort{
-									{
-										Name:          "http",
-										ContainerPort: 5678,
+							},
+							Env: env,
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/readyz",
+										Port: intstr.FromString("http"),
 									},
 								},
-								Env: []corev1.EnvVar{
-									{
-										Name:  "MODEL_REF",
-										Value: isvc.Spec.ModelRef,
-									},
-									{
-										Name:  "MAX_CONCURRENCY",
-										Value: strconv.Itoa(int(isvc.Spec.MaxConcurrency)),
+								PeriodSeconds: 2,
+							},
+							LivenessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/healthz",
+										Port: intstr.FromString("http"),
 									},
-									{
-										Name:  "KV_ENDPOINTS",
-										Value: strings.Join(cacheEndpoints, ","),
+								},
+							},
+							Lifecycle: &corev1.Lifecycle{
+								// Flip readiness and keep serving for the drain
+								// period before the kubelet sends SIGTERM. The
+								// drain endpoint only listens on the pod's
+								// loopback, so it is called from inside the
+								// container.
+								PreStop: &corev1.LifecycleHandler{
+									Exec: &corev1.ExecAction{
+										Command: []string{"/router", "drain"},
 									},
 								},
 							},
@@ -149,93 +360,121 @@ func (r *InferenceServiceReconciler) Reconcile(ctx context.Context, req ctrl.Req
 					},
 				},
 			},
-		}
+		},
+	}
+	if auth := isvc.Spec.Auth; auth != nil {
+		// A Secret volume, unlike env vars, is updated in place when the
+		// Secret changes, so the router can reload keys without a restart.
+		pod := &deploy.Spec.Template.Spec
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name: apiKeysVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: auth.SecretName},
+			},
+		})
+		pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: apiKeysVolumeName, MountPath: apiKeysMountPath, ReadOnly: true})
+	}
+	if a := isvc.Spec.Audit; a != nil {
+		pod := &deploy.Spec.Template.Spec
+		pod.Volumes = append(pod.Volumes, corev1.Volume{
+			Name:         auditVolumeName,
+			VolumeSource: auditVolumeSource(a),
+		})
+		pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: auditVolumeName, MountPath: auditMountPath})
+	}
+	return deploy
+}
 
-		if err := ctrl.SetControllerReference(&isvc, &deploy, r.Scheme); err != nil {
-			return ctrl.Result{}, err
-		}
+const (
+	apiKeysVolumeName = "api-keys"
+	apiKeysMountPath  = "/etc/llama-shepherd/api-keys"
 
-		if err := r.Create(ctx, &deploy); err != nil {
-			log.Error(err, "failed to create router Deployment", "deployment", deployName)
-			return ctrl.Result{}, err
-		}
-		log.Info("created router Deployment", "deployment", deployName)
-	} else if err == nil {
-		// Update replicas if changed
-		if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != replicas {
-			deploy.Spec.Replicas = ptr.To(replicas)
-			if err := r.Update(ctx, &deploy); err != nil {
-				log.Error(err, "failed to update router Deployment replicas", "deployment", deployName)
-				return ctrl.Result{}, err
-			}
-			log.Info("updated router Deployment replicas", "deployment", deployName, "replicas", replicas)
+	auditVolumeName = "audit"
+	auditMountPath  = "/var/log/llama-shepherd"
+)
+
+// auditVolumeSource returns where the router writes its audit log: the
+// named claim, or an emptyDir just large enough for the current file and
+// the rotated ones.
+func auditVolumeSource(a *llmv1alpha1.AuditSpec) corev1.VolumeSource {
+	if a.ClaimName != "" {
+		return corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: a.ClaimName},
 		}
-	} else {
-		return ctrl.Result{}, err
 	}
+	size := resource.NewQuantity(int64(a.MaxSizeMB)*int64(a.MaxFiles+2)<<20, resource.BinarySI)
+	return corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: size}}
+}
 
-	// Ensure Service exists
-	var svc corev1.Service
-	err = r.Get(ctx, client.ObjectKey{Name: svcName, Namespace: isvc.Namespace}, &svc)
-	if err != nil && errors.IsNotFound(err) {
-		svc = corev1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      svcName,
-				Namespace: isvc.Namespace,
-				Labels: map[string]string{
-					"app": deployName,
-				},
+// routerService returns the desired Service in front of the router pods.
+func routerService(isvc *llmv1alpha1.InferenceService, svcName, deployName string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: isvc.Namespace,
+			Labels: map[string]string{
+				"app":          deployName,
+				componentLabel: routerComponent,
 			},
-			Spec: corev1.ServiceSpec{
-				Selector: map[string]string{
-					"app": deployName,
-				},
-				Ports: []corev1.ServicePort{
-					{
-						Name:       "http",
-						Port:       80,
-						TargetPort: intstr.FromInt(5678),
-					},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": deployName,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Port:       80,
+					TargetPort: intstr.FromInt(5678),
 				},
 			},
-		}
-
-		if err := ctrl.SetControllerReference(&isvc, &svc, r.Scheme); err != nil {
-			return ctrl.Result{}, err
-		}
-
-		if err := r.Create(ctx, &svc); err != nil {
-			log.Error(err, "failed to create router Service", "service", svcName)
-			return ctrl.Result{}, err
-		}
-		log.Info("created router Service", "service", svcName)
-	} else if err != nil {
-		return ctrl.Result{}, err
-	}
-
-	// Update status with available replicas
-	available := int32(0)
-	if deploy.Status.AvailableReplicas > 0 {
-		available = deploy.Status.AvailableReplicas
-	}
-
-	if isvc.Status.AvailableReplicas != available {
-		isvc.Status.AvailableReplicas = available
-		if err := r.Status().Update(ctx, &isvc); err != nil {
-			log.Error(err, "failed to update InferenceService status")
-			return ctrl.Result{}, err
-		}
+		},
 	}
-
-	return ctrl.Result{}, nil
 }
 
+// cachePoolRefField indexes InferenceServices by spec.cachePoolRef.
+const cachePoolRefField = ".spec.cachePoolRef"
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *InferenceServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &llmv1alpha1.InferenceService{}, cachePoolRefField,
+		func(obj client.Object) []string {
+			ref := obj.(*llmv1alpha1.InferenceService).Spec.CachePoolRef
+			if ref == "" {
+				return nil
+			}
+			return []string{ref}
+		}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&llmv1alpha1.InferenceService{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Watches(&llmv1alpha1.KVCachePool{}, handler.EnqueueRequestsFromMapFunc(r.inferenceServicesForPool)).
 		Named("inferenceservice").
 		Complete(r)
 }
+
+// inferenceServicesForPool enqueues every InferenceService that references
+// pool, so creating, scaling or deleting a pool updates its dependents.
+func (r *InferenceServiceReconciler) inferenceServicesForPool(ctx context.Context,
+	pool client.Object) []reconcile.Request {
+	var list llmv1alpha1.InferenceServiceList
+	if err := r.List(ctx, &list,
+		client.InNamespace(pool.GetNamespace()),
+		client.MatchingFields{cachePoolRefField: pool.GetName()},
+	); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list InferenceServices for KVCachePool", "kvcachepool", pool.GetName())
+		return nil
+	}
+
+	reqs := make([]reconcile.Request, 0, len(list.Items))
+	for _, isvc := range list.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&isvc)})
+	}
+	return reqs
+}
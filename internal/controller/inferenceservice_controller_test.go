@@ -21,7 +21,9 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
@@ -77,8 +79,261 @@ var _ = Describe("InferenceService Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			By("reporting the unavailable router Deployment in the status conditions")
+			var isvc llmv1alpha1.InferenceService
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			Expect(isvc.Status.ObservedGeneration).To(Equal(isvc.Generation))
+			ready := meta.FindStatusCondition(isvc.Status.Conditions, llmv1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(llmv1alpha1.ReasonDeploymentUnavailable))
+		})
+	})
+
+	Context("When the InferenceService spec changes", func() {
+		const resourceName = "drift-isvc"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		deployKey := types.NamespacedName{Name: resourceName + "-router", Namespace: "default"}
+
+		BeforeEach(func() {
+			isvc := &llmv1alpha1.InferenceService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.InferenceServiceSpec{
+					ModelRef:       "model-a",
+					MaxConcurrency: 4,
+				},
+			}
+			Expect(k8sClient.Create(ctx, isvc)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			isvc := &llmv1alpha1.InferenceService{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, isvc)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, isvc)).To(Succeed())
+		})
+
+		It("should roll the change out and revert manual edits", func() {
+			controllerReconciler := &InferenceServiceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			reconcileOnce := func() {
+				_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: typeNamespacedName,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			routerEnv := func() map[string]string {
+				var deploy appsv1.Deployment
+				Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+				env := map[string]string{}
+				for _, e := range deploy.Spec.Template.Spec.Containers[0].Env {
+					env[e.Name] = e.Value
+				}
+				return env
+			}
+
+			reconcileOnce()
+			Expect(routerEnv()).To(HaveKeyWithValue("MODEL_REF", "model-a"))
+
+			By("changing modelRef and maxConcurrency")
+			var isvc llmv1alpha1.InferenceService
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.ModelRef = "model-b"
+			isvc.Spec.MaxConcurrency = 8
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(And(
+				HaveKeyWithValue("MODEL_REF", "model-b"),
+				HaveKeyWithValue("MAX_CONCURRENCY", "8"),
+			))
+
+			By("enabling micro-batching")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.Batching = &llmv1alpha1.BatchingSpec{MaxBatchSize: 4}
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(And(
+				HaveKeyWithValue("BATCH_MAX_SIZE", "4"),
+				HaveKeyWithValue("BATCH_MAX_WAIT", "10ms"),
+			))
+
+			By("capping the batch size at maxConcurrency")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.Batching.MaxBatchSize = 16
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(HaveKeyWithValue("BATCH_MAX_SIZE", "8"))
+
+			By("declaring priority tiers and tenant weights")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.Scheduling = &llmv1alpha1.SchedulingSpec{
+				Tiers:   []string{"interactive", "background"},
+				Tenants: []llmv1alpha1.TenantWeight{{Name: "chat", Weight: 3}, {Name: "reports"}},
+			}
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(And(
+				HaveKeyWithValue("SCHED_TIERS", "interactive,background"),
+				HaveKeyWithValue("SCHED_TENANT_WEIGHTS", "chat=3,reports=1"),
+			))
+
+			By("limiting tenants' request and token rates")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.RateLimit = &llmv1alpha1.RateLimitSpec{
+				RateLimitValues: llmv1alpha1.RateLimitValues{RequestsPerSecond: 5, TokensPerMinute: 60000},
+				Tenants: []llmv1alpha1.TenantRateLimit{{
+					Name:            "chat",
+					RateLimitValues: llmv1alpha1.RateLimitValues{RequestsPerSecond: 20, Burst: 40},
+				}},
+			}
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(And(
+				HaveKeyWithValue("RATE_LIMIT_RPS", "5"),
+				HaveKeyWithValue("RATE_LIMIT_TPM", "60000"),
+				HaveKeyWithValue("RATE_LIMIT_TENANTS", "chat=20:40:0"),
+				HaveKeyWithValue("RATE_LIMIT_SCOPE", "default/"+resourceName),
+			))
+
+			By("rejecting shared rate limits without a KVCachePool")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.RateLimit.Shared = true
+			err := k8sClient.Update(ctx, &isvc)
+			Expect(errors.IsInvalid(err)).To(BeTrue(), "update error: %v", err)
+
+			By("requiring API keys")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.Auth = &llmv1alpha1.AuthSpec{SecretName: "router-keys"}
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(HaveKeyWithValue("AUTH_DIR", "/etc/llama-shepherd/api-keys"))
+			var deploy appsv1.Deployment
+			Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Volumes).To(ConsistOf(
+				HaveField("Secret.SecretName", "router-keys"),
+			))
+
+			By("recording requests to an audit log")
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			isvc.Spec.Audit = &llmv1alpha1.AuditSpec{RedactPrompts: true, MaxSizeMB: 10, MaxFiles: 3}
+			Expect(k8sClient.Update(ctx, &isvc)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(And(
+				HaveKeyWithValue("AUDIT_DIR", "/var/log/llama-shepherd"),
+				HaveKeyWithValue("AUDIT_REDACT", "prompts"),
+				HaveKeyWithValue("AUDIT_MAX_FILES", "3"),
+			))
+			Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Volumes).To(ConsistOf(
+				HaveField("Name", "api-keys"),
+				And(HaveField("Name", "audit"), HaveField("EmptyDir.SizeLimit.String()", "50Mi")),
+			))
+			Expect(deploy.Spec.Template.Spec.Containers[0].VolumeMounts).To(HaveLen(2))
+
+			By("editing the router Deployment by hand")
+			Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+			for i, e := range deploy.Spec.Template.Spec.Containers[0].Env {
+				if e.Name == "MODEL_REF" {
+					deploy.Spec.Template.Spec.Containers[0].Env[i].Value = "hand-edited"
+				}
+			}
+			deploy.Spec.Template.Spec.Containers[0].Image = "example.com/other:latest"
+			Expect(k8sClient.Update(ctx, &deploy)).To(Succeed())
+			reconcileOnce()
+			Expect(routerEnv()).To(HaveKeyWithValue("MODEL_REF", "model-b"))
+			Expect(k8sClient.Get(ctx, deployKey, &deploy)).To(Succeed())
+			Expect(deploy.Spec.Template.Spec.Containers[0].Image).To(Equal("ghcr.io/vishalsanfran/llama-shepherd-router:latest"))
+			// The router is configured through its environment alone.
+			Expect(deploy.Spec.Template.Spec.Containers[0].Args).To(BeEmpty())
+		})
+	})
+
+	Context("When the referenced KVCachePool is missing", func() {
+		const resourceName = "orphan-isvc"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			isvc := &llmv1alpha1.InferenceService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.InferenceServiceSpec{
+					ModelRef:     "model-a",
+					CachePoolRef: "no-such-pool",
+				},
+			}
+			Expect(k8sClient.Create(ctx, isvc)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			isvc := &llmv1alpha1.InferenceService{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, isvc)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, isvc)).To(Succeed())
+		})
+
+		It("should report CachePoolNotFound until the pool appears", func() {
+			controllerReconciler := &InferenceServiceReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero(), "the KVCachePool watch replaces polling")
+
+			var isvc llmv1alpha1.InferenceService
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			ready := meta.FindStatusCondition(isvc.Status.Conditions, llmv1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(llmv1alpha1.ReasonCachePoolNotFound))
+			Expect(meta.IsStatusConditionTrue(isvc.Status.Conditions, llmv1alpha1.ConditionDegraded)).To(BeTrue())
+
+			By("creating the referenced pool")
+			pool := &llmv1alpha1.KVCachePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "no-such-pool",
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.KVCachePoolSpec{
+					TotalMemoryGB: 1,
+				},
+			}
+			Expect(k8sClient.Create(ctx, pool)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, pool)).To(Succeed())
+			})
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &isvc)).To(Succeed())
+			poolReady := meta.FindStatusCondition(isvc.Status.Conditions, llmv1alpha1.ConditionCachePoolReady)
+			Expect(poolReady).NotTo(BeNil())
+			Expect(poolReady.Status).To(Equal(metav1.ConditionFalse))
+			Expect(poolReady.Reason).To(Equal(llmv1alpha1.ReasonCachePoolNotReady))
+			Expect(meta.FindStatusCondition(isvc.Status.Conditions, llmv1alpha1.ConditionReady).Reason).
+				To(Equal(llmv1alpha1.ReasonDeploymentUnavailable))
 		})
 	})
 })
@@ -6,13 +6,15 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
 )
@@ -27,6 +29,7 @@ type KVCachePoolReconciler struct {
 // +kubebuilder:rbac:groups=llm.example.com,resources=kvcachepools/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=llm.example.com,resources=kvcachepools/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 
 func (r *KVCachePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -46,142 +49,321 @@ func (r *KVCachePoolReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	deployName := pool.Name + "-cache"
 
-	// 2. Ensure Deployment exists
-	var deploy appsv1.Deployment
-	err := r.Get(ctx, client.ObjectKey{Name: deployName, Namespace: pool.Namespace}, &deploy)
-	if err != nil && apierrors.IsNotFound(err) {
-		// Create a new Deployment of "cache nodes".
-		deploy = appsv1.Deployment{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      deployName,
-				Namespace: pool.Namespace,
-				Labels: map[string]string{
+	orig := pool.Status.DeepCopy()
+	pool.Status.ObservedGeneration = pool.Generation
+	conds := statusConditions{conditions: &pool.Status.Conditions, generation: pool.Generation}
+
+	// Unknown strategies are rejected by the CRD schema; pools created
+	// before it was tightened are not retried until they are fixed.
+	if _, err := evictionPolicy(pool.Spec.Strategy); err != nil {
+		log.Error(err, "invalid KVCachePool spec")
+		conds.failed(llmv1alpha1.ReasonInvalidSpec, err.Error())
+		if err := r.updateStatus(ctx, &pool, orig); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, reconcile.TerminalError(err)
+	}
+
+	// 2. Apply the headless Service. StatefulSet pods need it for their
+	// per-ordinal DNS names, and routers use it to discover every node.
+	err := r.applyHeadlessService(ctx, &pool, deployName)
+
+	// 3. Ensure the cache node workload exists
+	var ro rollout
+	if err == nil {
+		if pool.Spec.Workload == llmv1alpha1.KVCacheWorkloadStatefulSet {
+			ro, err = r.reconcileStatefulSet(ctx, &pool, deployName, replicas)
+		} else {
+			ro, err = r.reconcileDeployment(ctx, &pool, deployName, replicas)
+		}
+	}
+	if err != nil {
+		conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+		_ = r.updateStatus(ctx, &pool, orig)
+		return ctrl.Result{}, err
+	}
+
+	// 4. Update status based on workload status
+	pool.Status.ReadyReplicas = ro.ready
+	conds.setRollout(ro, "cache nodes", llmv1alpha1.ReasonCacheNodesUnavailable)
+	if err := r.updateStatus(ctx, &pool, orig); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// updateStatus writes pool's status if it differs from orig.
+func (r *KVCachePoolReconciler) updateStatus(ctx context.Context, pool *llmv1alpha1.KVCachePool,
+	orig *llmv1alpha1.KVCachePoolStatus) error {
+	if equality.Semantic.DeepEqual(orig, &pool.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, pool); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to update KVCachePool status")
+		return err
+	}
+	return nil
+}
+
+// reconcileDeployment runs the cache nodes as a Deployment and reports its
+// rollout.
+func (r *KVCachePoolReconciler) reconcileDeployment(ctx context.Context, pool *llmv1alpha1.KVCachePool,
+	deployName string, replicas int32) (rollout, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// Switching from StatefulSet mode leaves the old StatefulSet behind.
+	if err := r.deleteOwned(ctx, pool, &appsv1.StatefulSet{}, deployName); err != nil {
+		return rollout{}, err
+	}
+
+	// A Deployment of "cache nodes".
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deployName,
+			Namespace: pool.Namespace,
+			Labels: map[string]string{
+				"app": deployName,
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(replicas),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
 					"app": deployName,
 				},
 			},
-			Spec: appsv1.DeploymentSpec{
-				Replicas: ptr.To(replicas),
-				Selector: &metav1.LabelSelector{
-					MatchLabels: map[string]string{
-						"app": deployName,
-					},
+			Template: cachePodTemplate(pool, deployName, replicas),
+		},
+	}
+	if err := applyOwned(ctx, r.Client, r.Scheme, pool, deploy); err != nil {
+		log.Error(err, "failed to apply KV cache Deployment", "deployment", deployName)
+		return rollout{}, err
+	}
+
+	return deploymentRollout(deploy, replicas), nil
+}
+
+// reconcileStatefulSet runs the cache nodes as a StatefulSet behind the
+// headless Service, giving each node a stable <pool>-cache-<ordinal> identity
+// and, with persistence enabled, its own PersistentVolumeClaim. It reports the
+// StatefulSet's rollout.
+func (r *KVCachePoolReconciler) reconcileStatefulSet(ctx context.Context, pool *llmv1alpha1.KVCachePool,
+	stsName string, replicas int32) (rollout, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// Switching from Deployment mode leaves the old Deployment behind.
+	if err := r.deleteOwned(ctx, pool, &appsv1.Deployment{}, stsName); err != nil {
+		return rollout{}, err
+	}
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      stsName,
+			Namespace: pool.Namespace,
+			Labels: map[string]string{
+				"app": stsName,
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    ptr.To(replicas),
+			ServiceName: stsName,
+			// Nodes start in ordinal order and scale down from the
+			// highest ordinal, one at a time.
+			PodManagementPolicy: appsv1.OrderedReadyPodManagement,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": stsName,
 				},
-				Template: corev1.PodTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{
-						Labels: map[string]string{
-							"app": deployName,
-						},
-					},
-					Spec: corev1.PodSpec{
-						Containers: []corev1.Container{
-							{
-								Name: "cache",
-								// Placeholder KV node. Later, replace with your own Go-based KV service.
-								Image: "redis:7-alpine",
-								Ports: []corev1.ContainerPort{
-									{
-										Name:          "redis",
-										ContainerPort: 6379,
-									},
-								},
-								Env: []corev1.EnvVar{
-									{
-										Name:  "KVCACHE_STRATEGY",
-										Value: pool.Spec.Strategy,
-									},
-									{
-										Name:  "KVCACHE_TOTAL_MEMORY_GB",
-										Value: fmt.Sprintf("%d", pool.Spec.TotalMemoryGB),
-									},
-								},
-							},
+			},
+			Template: cachePodTemplate(pool, stsName, replicas),
+		},
+	}
+
+	if p := pool.Spec.Persistence; p != nil {
+		sts.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: cacheDataVolume,
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					StorageClassName: p.StorageClassName,
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceStorage: p.Size,
 						},
 					},
 				},
 			},
 		}
+	}
 
-		// 3. Ensure headless Service exists
-		svcName := pool.Name + "-cache"
+	// Volume claim templates are immutable, so changing persistence on an
+	// existing pool is rejected here until the StatefulSet is recreated.
+	if err := applyOwned(ctx, r.Client, r.Scheme, pool, sts); err != nil {
+		log.Error(err, "failed to apply KV cache StatefulSet", "statefulset", stsName)
+		return rollout{}, err
+	}
 
-		var svc corev1.Service
-		err = r.Get(ctx, client.ObjectKey{Name: svcName, Namespace: pool.Namespace}, &svc)
-		if err != nil && apierrors.IsNotFound(err) {
-			// Need to create headless Service
-			svc = corev1.Service{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      svcName,
-					Namespace: pool.Namespace,
-					Labels: map[string]string{
-						"app": deployName,
-					},
-				},
-				Spec: corev1.ServiceSpec{
-					ClusterIP: "None", // headless service
-					Selector: map[string]string{
-						"app": deployName,
-					},
-					Ports: []corev1.ServicePort{
-						{
-							Name:       "redis",
-							Port:       6379,
-							TargetPort: intstr.FromInt(6379),
-						},
-					},
+	return statefulSetRollout(sts, replicas), nil
+}
+
+// applyHeadlessService applies the headless Service that exposes every
+// cache node individually.
+func (r *KVCachePoolReconciler) applyHeadlessService(ctx context.Context, pool *llmv1alpha1.KVCachePool,
+	svcName string) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: pool.Namespace,
+			Labels: map[string]string{
+				"app": svcName,
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "None", // headless service
+			Selector: map[string]string{
+				"app": svcName,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "redis",
+					Port:       6379,
+					TargetPort: intstr.FromInt(6379),
 				},
-			}
+			},
+		},
+	}
+	if err := applyOwned(ctx, r.Client, r.Scheme, pool, svc); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to apply headless KV Service", "service", svcName)
+		return err
+	}
+	return nil
+}
 
-			if err := ctrl.SetControllerReference(&pool, &svc, r.Scheme); err != nil {
-				return ctrl.Result{}, err
-			}
+// deleteOwned deletes the named object if it exists and is controlled by
+// pool, so switching workload kinds does not leave two sets of cache nodes.
+func (r *KVCachePoolReconciler) deleteOwned(ctx context.Context, pool *llmv1alpha1.KVCachePool,
+	obj client.Object, name string) error {
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: pool.Namespace}, obj)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, pool) {
+		return nil
+	}
+	if err := r.Delete(ctx, obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	ctrl.LoggerFrom(ctx).Info("deleted KV cache workload after workload change",
+		"kind", fmt.Sprintf("%T", obj), "name", name)
+	return nil
+}
 
-			if err := r.Create(ctx, &svc); err != nil {
-				log.Error(err, "failed to create headless KV Service", "service", svcName)
-				return ctrl.Result{}, err
-			}
+// cacheDataVolume is the name of the per-node PersistentVolumeClaim template.
+const cacheDataVolume = "data"
 
-			log.Info("created headless KV Service", "service", svcName)
+// cachePodTemplate is the pod template shared by both workload kinds.
+func cachePodTemplate(pool *llmv1alpha1.KVCachePool, name string, replicas int32) corev1.PodTemplateSpec {
+	// Strategy has been validated by the caller.
+	policy, _ := evictionPolicy(pool.Spec.Strategy)
+	maxMemoryMiB, containerMemory := cacheMemory(pool.Spec, replicas)
 
-		} else if err != nil {
-			// Some other error
-			return ctrl.Result{}, err
-		}
+	args := []string{
+		"redis-server",
+		"--maxmemory", fmt.Sprintf("%dmb", maxMemoryMiB),
+		"--maxmemory-policy", policy,
+	}
 
-		if err := ctrl.SetControllerReference(&pool, &deploy, r.Scheme); err != nil {
-			return ctrl.Result{}, err
-		}
+	container := corev1.Container{
+		Name: "cache",
+		// Placeholder KV node. Later, replace with your own Go-based KV service.
+		Image: "redis:7-alpine",
+		Args:  args,
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "redis",
+				ContainerPort: 6379,
+			},
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "KVCACHE_STRATEGY",
+				Value: pool.Spec.Strategy,
+			},
+			{
+				Name:  "KVCACHE_TOTAL_MEMORY_GB",
+				Value: fmt.Sprintf("%d", pool.Spec.TotalMemoryGB),
+			},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceMemory: containerMemory,
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: containerMemory,
+			},
+		},
+	}
 
-		if err := r.Create(ctx, &deploy); err != nil {
-			log.Error(err, "failed to create KV cache Deployment", "deployment", deployName)
-			return ctrl.Result{}, err
+	if pool.Spec.Workload == llmv1alpha1.KVCacheWorkloadStatefulSet && pool.Spec.Persistence != nil {
+		// The redis image runs in /data; append-only files land on the claim.
+		container.Args = append(container.Args, "--appendonly", "yes")
+		container.VolumeMounts = []corev1.VolumeMount{
+			{
+				Name:      cacheDataVolume,
+				MountPath: "/data",
+			},
 		}
+	}
 
-		log.Info("created KV cache Deployment", "deployment", deployName)
-	} else if err == nil {
-		// Keep replicas in sync
-		if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != replicas {
-			deploy.Spec.Replicas = ptr.To(replicas)
-			if err := r.Update(ctx, &deploy); err != nil {
-				log.Error(err, "failed to update KV cache Deployment replicas", "deployment", deployName)
-				return ctrl.Result{}, err
-			}
-			log.Info("updated KV cache Deployment replicas", "deployment", deployName, "replicas", replicas)
-		}
-	} else {
-		return ctrl.Result{}, err
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"app": name,
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{container},
+		},
 	}
+}
 
-	// 3. Update status.ReadyReplicas based on Deployment status
-	ready := deploy.Status.ReadyReplicas
-	if pool.Status.ReadyReplicas != ready {
-		pool.Status.ReadyReplicas = ready
-		if err := r.Status().Update(ctx, &pool); err != nil {
-			log.Error(err, "failed to update KVCachePool status")
-			return ctrl.Result{}, err
-		}
+// evictionPolicy maps a KVCachePool strategy onto a Redis maxmemory-policy.
+func evictionPolicy(strategy string) (string, error) {
+	switch strategy {
+	case "", "lru":
+		return "allkeys-lru", nil
+	case "lfu":
+		return "allkeys-lfu", nil
+	case "rr":
+		return "allkeys-random", nil
+	default:
+		return "", fmt.Errorf("unknown KVCachePool strategy %q: must be one of lru, lfu, rr", strategy)
 	}
+}
 
-	return ctrl.Result{}, nil
+// cacheMemoryOverheadPercent is the container memory added on top of Redis
+// maxmemory for its own bookkeeping, buffers and fragmentation.
+const cacheMemoryOverheadPercent = 25
+
+// cacheMemory sizes one cache node: memoryPerReplicaMB if set, otherwise an
+// even share of totalMemoryGB. It returns the node's Redis maxmemory in MiB
+// and the container memory request and limit, which include
+// cacheMemoryOverheadPercent of headroom so eviction kicks in before the
+// kubelet's OOM killer does. maxmemory is at least 1 MiB, since Redis reads
+// 0 as unlimited.
+func cacheMemory(spec llmv1alpha1.KVCachePoolSpec, replicas int32) (int64, resource.Quantity) {
+	var maxMemoryMiB int64
+	if spec.MemoryPerReplicaMB != nil {
+		maxMemoryMiB = int64(*spec.MemoryPerReplicaMB)
+	} else {
+		maxMemoryMiB = int64(spec.TotalMemoryGB) * 1024 / int64(max(replicas, 1))
+	}
+	maxMemoryMiB = max(maxMemoryMiB, 1)
+	containerMiB := maxMemoryMiB * (100 + cacheMemoryOverheadPercent) / 100
+	return maxMemoryMiB, *resource.NewQuantity(containerMiB*1024*1024, resource.BinarySI)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -189,6 +371,8 @@ func (r *KVCachePoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&llmv1alpha1.KVCachePool{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
 		Named("kvcachepool").
 		Complete(r)
 }
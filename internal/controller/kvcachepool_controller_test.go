@@ -21,8 +21,13 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -51,7 +56,9 @@ var _ = Describe("KVCachePool Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: llmv1alpha1.KVCachePoolSpec{
+						TotalMemoryGB: 1,
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -77,8 +84,125 @@ var _ = Describe("KVCachePool Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			By("reporting the unavailable cache nodes in the status conditions")
+			var pool llmv1alpha1.KVCachePool
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &pool)).To(Succeed())
+			Expect(pool.Status.ObservedGeneration).To(Equal(pool.Generation))
+			ready := meta.FindStatusCondition(pool.Status.Conditions, llmv1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(llmv1alpha1.ReasonCacheNodesUnavailable))
+		})
+	})
+
+	Context("When the pool uses the StatefulSet workload", func() {
+		const resourceName = "sts-pool"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			By("creating a KVCachePool with persistence")
+			pool := &llmv1alpha1.KVCachePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.KVCachePoolSpec{
+					TotalMemoryGB: 4,
+					Replicas:      ptr.To(int32(3)),
+					Workload:      llmv1alpha1.KVCacheWorkloadStatefulSet,
+					Persistence: &llmv1alpha1.KVCachePersistence{
+						Size: resource.MustParse("1Gi"),
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, pool)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			pool := &llmv1alpha1.KVCachePool{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, pool)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, pool)).To(Succeed())
+		})
+
+		It("should create a StatefulSet behind the headless Service", func() {
+			controllerReconciler := &KVCachePoolReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var svc corev1.Service
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}, &svc)).To(Succeed())
+			Expect(svc.Spec.ClusterIP).To(Equal("None"))
+
+			var sts appsv1.StatefulSet
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}, &sts)).To(Succeed())
+			Expect(sts.Spec.ServiceName).To(Equal(resourceName + "-cache"))
+			Expect(*sts.Spec.Replicas).To(Equal(int32(3)))
+			Expect(sts.Spec.PodManagementPolicy).To(Equal(appsv1.OrderedReadyPodManagement))
+			Expect(sts.Spec.VolumeClaimTemplates).To(HaveLen(1))
+			Expect(sts.Spec.Template.Spec.Containers[0].VolumeMounts).To(HaveLen(1))
+
+			By("splitting totalMemoryGB across the three nodes")
+			cache := sts.Spec.Template.Spec.Containers[0]
+			Expect(cache.Args).To(ContainElements("--maxmemory", "1365mb", "--maxmemory-policy", "allkeys-lru"))
+			Expect(cache.Resources.Requests.Memory().String()).To(Equal("1706Mi"))
+			Expect(cache.Resources.Limits.Memory().String()).To(Equal("1706Mi"))
+
+			By("sizing nodes per replica so scaling keeps the pod template")
+			var pool llmv1alpha1.KVCachePool
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &pool)).To(Succeed())
+			pool.Spec.MemoryPerReplicaMB = ptr.To(int32(512))
+			Expect(k8sClient.Update(ctx, &pool)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}, &sts)).To(Succeed())
+			template := sts.Spec.Template.DeepCopy()
+			Expect(template.Spec.Containers[0].Args).To(ContainElements("--maxmemory", "512mb"))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &pool)).To(Succeed())
+			pool.Spec.Replicas = ptr.To(int32(5))
+			Expect(k8sClient.Update(ctx, &pool)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}, &sts)).To(Succeed())
+			Expect(*sts.Spec.Replicas).To(Equal(int32(5)))
+			Expect(sts.Spec.Template.Spec.Containers[0].Args).To(Equal(template.Spec.Containers[0].Args))
+			Expect(sts.Spec.Template.Spec.Containers[0].Resources).To(Equal(template.Spec.Containers[0].Resources))
+
+			var deploy appsv1.Deployment
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}, &deploy)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should recreate a deleted headless Service", func() {
+			controllerReconciler := &KVCachePoolReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			svcKey := types.NamespacedName{Name: resourceName + "-cache", Namespace: "default"}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			var svc corev1.Service
+			Expect(k8sClient.Get(ctx, svcKey, &svc)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &svc)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, svcKey, &svc)).To(Succeed())
+			Expect(svc.Spec.ClusterIP).To(Equal("None"))
 		})
 	})
 })
@@ -0,0 +1,221 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+const (
+	batchInputVolume  = "input"
+	batchOutputVolume = "output"
+	// batchPromptsKey is the key inline prompts are written to in the
+	// generated ConfigMap.
+	batchPromptsKey = "prompts.jsonl"
+)
+
+// promptsConfigMapName names the ConfigMap holding a batch's inline prompts.
+func promptsConfigMapName(cr *llmv1alpha1.LLMInferenceJob) string {
+	return cr.Name + "-prompts"
+}
+
+// promptsConfigMap returns the ConfigMap that carries inline batch prompts
+// to the runner pods as JSONL, one JSON string per line.
+func promptsConfigMap(cr *llmv1alpha1.LLMInferenceJob) (*corev1.ConfigMap, error) {
+	var b strings.Builder
+	for _, p := range cr.Spec.Batch.Prompts {
+		line, err := json.Marshal(p)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      promptsConfigMapName(cr),
+			Namespace: cr.Namespace,
+		},
+		Data: map[string]string{
+			batchPromptsKey: b.String(),
+		},
+	}, nil
+}
+
+// configureBatch turns job into an indexed Job with one completion per shard,
+// mounting the prompts and the output claim into every pod.
+func configureBatch(cr *llmv1alpha1.LLMInferenceJob, job *batchv1.Job) {
+	batch := cr.Spec.Batch
+	shards := max(batch.Shards, 1)
+	parallelism := shards
+	if batch.Parallelism != nil {
+		parallelism = *batch.Parallelism
+	}
+
+	job.Spec.CompletionMode = ptr.To(batchv1.IndexedCompletion)
+	job.Spec.Completions = ptr.To(shards)
+	job.Spec.Parallelism = ptr.To(parallelism)
+
+	var input corev1.Volume
+	inputFile := batchPromptsKey
+	switch {
+	case batch.PersistentVolumeClaim != nil:
+		input = corev1.Volume{
+			Name: batchInputVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: batch.PersistentVolumeClaim.ClaimName,
+					ReadOnly:  true,
+				},
+			},
+		}
+		inputFile = batch.PersistentVolumeClaim.Path
+	default:
+		cm, key := promptsConfigMapName(cr), batchPromptsKey
+		if batch.ConfigMap != nil {
+			cm, key = batch.ConfigMap.Name, batch.ConfigMap.Key
+		}
+		input = corev1.Volume{
+			Name: batchInputVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm},
+					Items:                []corev1.KeyToPath{{Key: key, Path: batchPromptsKey}},
+				},
+			},
+		}
+	}
+
+	pod := &job.Spec.Template.Spec
+	pod.Volumes = []corev1.Volume{
+		input,
+		{
+			Name: batchOutputVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: batch.Output.ClaimName,
+				},
+			},
+		},
+	}
+
+	runner := &pod.Containers[0]
+	runner.VolumeMounts = []corev1.VolumeMount{
+		{Name: batchInputVolume, MountPath: "/input", ReadOnly: true},
+		{Name: batchOutputVolume, MountPath: "/output"},
+	}
+	runner.Env = append(runner.Env,
+		corev1.EnvVar{Name: "BATCH_INPUT", Value: path.Join("/input", inputFile)},
+		corev1.EnvVar{Name: "BATCH_OUTPUT", Value: path.Join("/output", batch.Output.Path)},
+		corev1.EnvVar{Name: "BATCH_SHARDS", Value: strconv.Itoa(int(shards))},
+	)
+}
+
+// batchOutput is where a batch job's results go, as pvc://<claim>/<path>.
+func batchOutput(cr *llmv1alpha1.LLMInferenceJob) string {
+	out := cr.Spec.Batch.Output
+	return "pvc://" + path.Join(out.ClaimName, out.Path)
+}
+
+// shardStatuses reports every shard of an indexed runner Job from the Job's
+// completed indexes and its pods: a succeeded shard's pod left a JSON
+// summary in its termination message.
+func shardStatuses(cr *llmv1alpha1.LLMInferenceJob, job *batchv1.Job,
+	pods []corev1.Pod) []llmv1alpha1.LLMInferenceShardStatus {
+	shards := max(cr.Spec.Batch.Shards, 1)
+	completed := parseIndexes(job.Status.CompletedIndexes)
+	failed := jobCondition(job, batchv1.JobFailed) != nil
+
+	out := make([]llmv1alpha1.LLMInferenceShardStatus, shards)
+	for i := range out {
+		out[i] = llmv1alpha1.LLMInferenceShardStatus{
+			Index:  int32(i),
+			Phase:  llmv1alpha1.ShardPending,
+			Output: fmt.Sprintf("%s/shard-%d.jsonl", batchOutput(cr), i),
+		}
+		switch {
+		case completed[int32(i)]:
+			out[i].Phase = llmv1alpha1.ShardSucceeded
+		case failed:
+			out[i].Phase = llmv1alpha1.ShardFailed
+		}
+	}
+
+	for _, pod := range pods {
+		idx, err := strconv.Atoi(pod.Annotations[batchv1.JobCompletionIndexAnnotation])
+		if err != nil || idx < 0 || idx >= len(out) {
+			continue
+		}
+		shard := &out[idx]
+		if shard.Phase == llmv1alpha1.ShardPending && pod.Status.Phase == corev1.PodRunning {
+			shard.Phase = llmv1alpha1.ShardRunning
+		}
+		if shard.Phase != llmv1alpha1.ShardSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if cs.Name != runnerContainer || t == nil || t.ExitCode != 0 {
+				continue
+			}
+			var summary struct {
+				Prompts int32 `json:"prompts"`
+				Errors  int32 `json:"errors"`
+			}
+			if json.Unmarshal([]byte(t.Message), &summary) == nil {
+				shard.Prompts, shard.Errors = summary.Prompts, summary.Errors
+			}
+		}
+	}
+	return out
+}
+
+// parseIndexes parses a Job's completedIndexes, e.g. "1,3-5".
+func parseIndexes(s string) map[int32]bool {
+	out := map[int32]bool{}
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				continue
+			}
+		}
+		for i := first; i <= last; i++ {
+			out[int32(i)] = true
+		}
+	}
+	return out
+}
@@ -19,13 +19,22 @@ package controller
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
 )
@@ -34,11 +43,24 @@ import (
 type LLMInferenceJobReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// PodLogs reads runner logs when a generation is too long for a
+	// termination message. Without it, such output is cut at 4 KiB.
+	PodLogs corev1client.PodsGetter
+
+	// HistoryLimit keeps at most this many finished LLMInferenceJobs in
+	// each namespace, deleting the oldest. Zero keeps them all.
+	HistoryLimit int
 }
 
 // +kubebuilder:rbac:groups=llm.example.com,resources=llminferencejobs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=llm.example.com,resources=llminferencejobs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=llm.example.com,resources=llminferencejobs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=llm.example.com,resources=inferenceservices,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -52,69 +74,410 @@ type LLMInferenceJobReconciler struct {
 func (r *LLMInferenceJobReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
 	var cr llmv1alpha1.LLMInferenceJob
-	err := r.Get(ctx, req.NamespacedName, &cr)
-	if err != nil {
-		return ctrl.Result{}, nil
+	if err := r.Get(ctx, req.NamespacedName, &cr); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if finished(&cr) {
+		return r.collect(ctx, &cr)
+	}
+
+	orig := cr.Status.DeepCopy()
+	cr.Status.ObservedGeneration = cr.Generation
+	if cr.Status.Phase == "" {
+		cr.Status.Phase = llmv1alpha1.JobPhasePending
+	}
+	conds := statusConditions{conditions: &cr.Status.Conditions, generation: cr.Generation}
+
+	// Resolve the target once; the Job's pod template cannot change later.
+	if cr.Status.InferenceService == "" {
+		isvc, err := r.resolveInferenceService(ctx, &cr)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if isvc == "" {
+			msg := fmt.Sprintf("no InferenceService named %q", cr.Spec.InferenceServiceRef)
+			if cr.Spec.InferenceServiceRef == "" {
+				msg = fmt.Sprintf("no InferenceService serves model %q", cr.Spec.ModelRef)
+			}
+			conds.set(llmv1alpha1.ConditionReady, metav1.ConditionFalse, llmv1alpha1.ReasonInferenceServiceNotFound, msg)
+			conds.set(llmv1alpha1.ConditionProgressing, metav1.ConditionFalse, llmv1alpha1.ReasonInferenceServiceNotFound, msg)
+			conds.set(llmv1alpha1.ConditionDegraded, metav1.ConditionTrue, llmv1alpha1.ReasonInferenceServiceNotFound, msg)
+			// The InferenceService watch brings us back once one appears.
+			return ctrl.Result{}, r.updateStatus(ctx, &cr, orig)
+		}
+		cr.Status.InferenceService = isvc
 	}
 
-	if cr.Status.Completed {
-		return ctrl.Result{}, nil
+	if b := cr.Spec.Batch; b != nil && b.ConfigMap == nil && b.PersistentVolumeClaim == nil {
+		cm, err := promptsConfigMap(&cr)
+		if err == nil {
+			err = applyOwned(ctx, r.Client, r.Scheme, &cr, cm)
+		}
+		if err != nil {
+			log.Error(err, "failed to apply prompts ConfigMap", "configmap", promptsConfigMapName(&cr))
+			conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+			_ = r.updateStatus(ctx, &cr, orig)
+			return ctrl.Result{}, err
+		}
 	}
 
 	jobName := cr.Name + "-runner"
-	var k8sJob batchv1.Job
-	err = r.Get(ctx, client.ObjectKey{Name: jobName, Namespace: cr.Namespace}, &k8sJob)
-	if err == nil {
-		if k8sJob.Status.Succeeded > 0 {
-			cr.Status.Completed = true
-			cr.Status.Output = "finished (dummy output)"
-			if err := r.Status().Update(ctx, &cr); err != nil {
-				log.Error(err, "failed to update status")
+	job := runnerJob(&cr, jobName)
+
+	if err := applyOwned(ctx, r.Client, r.Scheme, &cr, job); err != nil {
+		// A Job's pod template is immutable, so a spec edited after the
+		// Job started cannot be applied; retrying will not help.
+		if apierrors.IsInvalid(err) {
+			log.Error(err, "LLMInferenceJob spec no longer matches its job", "job", jobName)
+			cr.Status.Phase = llmv1alpha1.JobPhaseFailed
+			cr.Status.Message = err.Error()
+			cr.Status.CompletionTime = ptr.To(metav1.Now())
+			conds.failed(llmv1alpha1.ReasonInvalidSpec, err.Error())
+			if err := r.updateStatus(ctx, &cr, orig); err != nil {
 				return ctrl.Result{}, err
 			}
+			return ctrl.Result{}, reconcile.TerminalError(err)
+		}
+		log.Error(err, "failed to apply job", "job", jobName)
+		conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+		_ = r.updateStatus(ctx, &cr, orig)
+		return ctrl.Result{}, err
+	}
+
+	pods, err := r.runnerPods(ctx, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	succeeded := job.Status.Succeeded > 0
+	if cr.Spec.Batch != nil {
+		// Every shard has to finish, not just one.
+		succeeded = jobCondition(job, batchv1.JobComplete) != nil
+		cr.Status.Shards = shardStatuses(&cr, job, pods)
+	}
+
+	switch failed := jobCondition(job, batchv1.JobFailed); {
+	case succeeded:
+		if cr.Spec.Batch != nil {
+			cr.Status.OutputRef = batchOutput(&cr)
+		} else if err := r.recordOutput(ctx, &cr, pods); err != nil {
+			log.Error(err, "failed to record output", "configmap", outputConfigMapName(&cr))
+			conds.failed(llmv1alpha1.ReasonApplyFailed, err.Error())
+			_ = r.updateStatus(ctx, &cr, orig)
+			return ctrl.Result{}, err
+		}
+		cr.Status.Phase = llmv1alpha1.JobPhaseSucceeded
+		cr.Status.Completed = true
+		cr.Status.CompletionTime = ptr.To(metav1.Now())
+		msg := fmt.Sprintf("job %s succeeded", jobName)
+		conds.set(llmv1alpha1.ConditionReady, metav1.ConditionTrue, llmv1alpha1.ReasonJobSucceeded, msg)
+		conds.set(llmv1alpha1.ConditionProgressing, metav1.ConditionFalse, llmv1alpha1.ReasonJobSucceeded, msg)
+		conds.set(llmv1alpha1.ConditionDegraded, metav1.ConditionFalse, llmv1alpha1.ReasonAsExpected, "")
+	case failed != nil:
+		cr.Status.Phase = llmv1alpha1.JobPhaseFailed
+		cr.Status.Message = failed.Message
+		cr.Status.CompletionTime = ptr.To(metav1.Now())
+		if msg := runnerFailure(pods); msg != "" {
+			cr.Status.Message = msg
+		}
+		log.Info("LLMInferenceJob failed", "job", jobName, "reason", failed.Reason, "message", cr.Status.Message)
+		conds.failed(jobFailureReason(failed), fmt.Sprintf("job %s failed: %s", jobName, cr.Status.Message))
+	default:
+		msg := fmt.Sprintf("job %s is running", jobName)
+		if job.Status.Active > 0 || job.Status.Failed > 0 || job.Status.Succeeded > 0 {
+			cr.Status.Phase = llmv1alpha1.JobPhaseRunning
+		} else {
+			msg = fmt.Sprintf("job %s is waiting for runner pods", jobName)
+		}
+		if job.Status.Failed > 0 {
+			msg += fmt.Sprintf("; %d failed attempts", job.Status.Failed)
+			if last := runnerFailure(pods); last != "" {
+				msg += ", last: " + last
+			}
+		}
+		conds.set(llmv1alpha1.ConditionReady, metav1.ConditionFalse, llmv1alpha1.ReasonJobRunning, msg)
+		conds.set(llmv1alpha1.ConditionProgressing, metav1.ConditionTrue, llmv1alpha1.ReasonJobRunning, msg)
+		conds.set(llmv1alpha1.ConditionDegraded, metav1.ConditionFalse, llmv1alpha1.ReasonAsExpected, "")
+	}
+	if err := r.updateStatus(ctx, &cr, orig); err != nil {
+		return ctrl.Result{}, err
+	}
+	if finished(&cr) {
+		return r.collect(ctx, &cr)
+	}
+	// Owned Job events bring us back as the runner progresses.
+	return ctrl.Result{}, nil
+}
+
+// finished reports whether cr reached a final phase. Jobs that completed
+// before phases existed only have Completed set.
+func finished(cr *llmv1alpha1.LLMInferenceJob) bool {
+	switch cr.Status.Phase {
+	case llmv1alpha1.JobPhaseSucceeded, llmv1alpha1.JobPhaseFailed:
+		return true
+	}
+	return cr.Status.Completed
+}
+
+// resolveInferenceService returns the name of the InferenceService cr should
+// be sent to, or "" if there is none yet. An explicit inferenceServiceRef
+// wins; otherwise the first InferenceService by name serving modelRef is
+// used.
+func (r *LLMInferenceJobReconciler) resolveInferenceService(ctx context.Context,
+	cr *llmv1alpha1.LLMInferenceJob) (string, error) {
+	if ref := cr.Spec.InferenceServiceRef; ref != "" {
+		var isvc llmv1alpha1.InferenceService
+		err := r.Get(ctx, client.ObjectKey{Name: ref, Namespace: cr.Namespace}, &isvc)
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return ref, nil
+	}
+
+	var list llmv1alpha1.InferenceServiceList
+	if err := r.List(ctx, &list, client.InNamespace(cr.Namespace)); err != nil {
+		return "", err
+	}
+	var names []string
+	for _, isvc := range list.Items {
+		if isvc.Spec.ModelRef == cr.Spec.ModelRef && isvc.DeletionTimestamp == nil {
+			names = append(names, isvc.Name)
 		}
 	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	slices.Sort(names)
+	return names[0], nil
+}
+
+// runnerJob returns the Job that submits cr's prompt to the InferenceService
+// recorded in its status.
+func runnerJob(cr *llmv1alpha1.LLMInferenceJob, jobName string) *batchv1.Job {
+	image := cr.Spec.RunnerImage
+	if image == "" {
+		image = llmv1alpha1.DefaultRunnerImage
+	}
 
-	job := batchv1.Job{
+	env := []corev1.EnvVar{
+		{
+			// The router Service is named after the InferenceService.
+			Name:  "ENDPOINT",
+			Value: fmt.Sprintf("http://%s.%s.svc.cluster.local", cr.Status.InferenceService, cr.Namespace),
+		},
+		{
+			Name:  "MODEL",
+			Value: cr.Spec.ModelRef,
+		},
+	}
+	switch {
+	case cr.Spec.Batch != nil:
+	case cr.Spec.PromptFrom != nil:
+		env = append(env, corev1.EnvVar{
+			Name:  "PROMPT_FILE",
+			Value: promptMountPath + "/" + promptFile,
+		})
+	default:
+		// Passed as a plain environment variable: the runner never goes
+		// through a shell, so the prompt needs no quoting.
+		env = append(env, corev1.EnvVar{
+			Name:  "PROMPT",
+			Value: cr.Spec.Prompt,
+		})
+	}
+	if ref := cr.Spec.APIKeySecretRef; ref != nil {
+		env = append(env, corev1.EnvVar{
+			Name:      "API_KEY",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref},
+		})
+	}
+	if cr.Spec.MaxTokens != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  "MAX_TOKENS",
+			Value: strconv.Itoa(int(*cr.Spec.MaxTokens)),
+		})
+	}
+
+	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      jobName,
 			Namespace: cr.Namespace,
 		},
 		Spec: batchv1.JobSpec{
+			BackoffLimit:          cr.Spec.BackoffLimit,
+			ActiveDeadlineSeconds: cr.Spec.ActiveDeadlineSeconds,
 			Template: corev1.PodTemplateSpec{
 				Spec: corev1.PodSpec{
 					RestartPolicy: corev1.RestartPolicyNever,
 					Containers: []corev1.Container{
 						{
-							Name:  "runner",
-							Image: "busybox",
-							Command: []string{
-								"sh", "-c", fmt.Sprintf("echo '%s'; sleep 2", cr.Spec.Prompt),
-							},
+							Name:  runnerContainer,
+							Image: image,
+							Env:   env,
+							// A runner that dies without writing a
+							// termination message still explains itself.
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
 						},
 					},
 				},
 			},
 		},
 	}
+	if src := cr.Spec.PromptFrom; src != nil {
+		job.Spec.Template.Spec.Volumes = []corev1.Volume{promptVolume(src)}
+		job.Spec.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+			{Name: promptVolumeName, MountPath: promptMountPath, ReadOnly: true},
+		}
+	}
+	if cr.Spec.Batch != nil {
+		configureBatch(cr, job)
+	}
+	return job
+}
 
-	err = ctrl.SetControllerReference(&cr, &job, r.Scheme)
-	if err != nil {
-		return ctrl.Result{}, nil
+const (
+	// runnerContainer is the name of the container in runner pods.
+	runnerContainer = "runner"
+
+	promptVolumeName = "prompt"
+	promptMountPath  = "/prompt"
+	// promptFile is the file spec.promptFrom's key is projected to.
+	promptFile = "prompt"
+)
+
+// promptVolume projects the key selected by src to promptFile.
+func promptVolume(src *llmv1alpha1.PromptSource) corev1.Volume {
+	vol := corev1.Volume{Name: promptVolumeName}
+	if ref := src.SecretKeyRef; ref != nil {
+		vol.Secret = &corev1.SecretVolumeSource{
+			SecretName: ref.Name,
+			Items:      []corev1.KeyToPath{{Key: ref.Key, Path: promptFile}},
+		}
+		return vol
 	}
-	err = r.Create(ctx, &job)
-	if err != nil {
-		return ctrl.Result{}, nil
+	ref := src.ConfigMapKeyRef
+	vol.ConfigMap = &corev1.ConfigMapVolumeSource{
+		LocalObjectReference: ref.LocalObjectReference,
+		Items:                []corev1.KeyToPath{{Key: ref.Key, Path: promptFile}},
 	}
+	return vol
+}
 
-	log.Info("created job", "job", jobName)
-	return ctrl.Result{}, nil
+// runnerPods lists the pods of a runner Job.
+func (r *LLMInferenceJobReconciler) runnerPods(ctx context.Context, job *batchv1.Job) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods,
+		client.InNamespace(job.Namespace),
+		client.MatchingLabels{batchv1.JobNameLabel: job.Name},
+	); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// runnerOutput returns the succeeded runner pod and the generated text it
+// left in its termination message.
+func runnerOutput(pods []corev1.Pod) (*corev1.Pod, string) {
+	for i := range pods {
+		for _, cs := range pods[i].Status.ContainerStatuses {
+			if t := cs.State.Terminated; cs.Name == runnerContainer && t != nil && t.ExitCode == 0 {
+				return &pods[i], t.Message
+			}
+		}
+	}
+	return nil, ""
+}
+
+// runnerFailure returns why the runner container that failed last did so:
+// its termination message, or its exit reason if it left none. It returns
+// "" if no runner has failed.
+func runnerFailure(pods []corev1.Pod) string {
+	var last *corev1.ContainerStateTerminated
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if cs.Name != runnerContainer || t == nil || t.ExitCode == 0 {
+				continue
+			}
+			if last == nil || t.FinishedAt.After(last.FinishedAt.Time) {
+				last = t
+			}
+		}
+	}
+	switch {
+	case last == nil:
+		return ""
+	case strings.TrimSpace(last.Message) != "":
+		return strings.TrimSpace(last.Message)
+	default:
+		return fmt.Sprintf("%s (exit code %d)", last.Reason, last.ExitCode)
+	}
+}
+
+// jobFailureReason maps the reason a Job failed to a condition reason.
+func jobFailureReason(failed *batchv1.JobCondition) string {
+	switch failed.Reason {
+	case batchv1.JobReasonBackoffLimitExceeded:
+		return llmv1alpha1.ReasonBackoffLimitExceeded
+	case batchv1.JobReasonDeadlineExceeded:
+		return llmv1alpha1.ReasonDeadlineExceeded
+	}
+	return llmv1alpha1.ReasonJobFailed
+}
+
+// updateStatus writes cr's status if it differs from orig.
+func (r *LLMInferenceJobReconciler) updateStatus(ctx context.Context, cr *llmv1alpha1.LLMInferenceJob,
+	orig *llmv1alpha1.LLMInferenceJobStatus) error {
+	if equality.Semantic.DeepEqual(orig, &cr.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, cr); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to update status")
+		return err
+	}
+	return nil
+}
+
+// jobCondition returns job's condition of type t if it is True.
+func jobCondition(job *batchv1.Job, t batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if c := &job.Status.Conditions[i]; c.Type == t && c.Status == corev1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *LLMInferenceJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&llmv1alpha1.LLMInferenceJob{}).
+		Owns(&batchv1.Job{}).
+		Watches(&llmv1alpha1.InferenceService{}, handler.EnqueueRequestsFromMapFunc(r.jobsWaitingForInferenceService)).
 		Named("llminferencejob").
 		Complete(r)
 }
+
+// jobsWaitingForInferenceService enqueues the jobs in isvc's namespace that
+// have not been matched to an InferenceService yet.
+func (r *LLMInferenceJobReconciler) jobsWaitingForInferenceService(ctx context.Context,
+	isvc client.Object) []reconcile.Request {
+	var list llmv1alpha1.LLMInferenceJobList
+	if err := r.List(ctx, &list, client.InNamespace(isvc.GetNamespace())); err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to list LLMInferenceJobs for InferenceService",
+			"inferenceservice", isvc.GetName())
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, cr := range list.Items {
+		if cr.Status.InferenceService == "" && !finished(&cr) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cr)})
+		}
+	}
+	return reqs
+}
@@ -18,11 +18,20 @@ package controller
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -51,7 +60,10 @@ var _ = Describe("LLMInferenceJob Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: llmv1alpha1.LLMInferenceJobSpec{
+						Prompt:   "hello",
+						ModelRef: "unserved-model",
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -77,8 +89,550 @@ var _ = Describe("LLMInferenceJob Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			By("waiting for an InferenceService that serves the model")
+			var job llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &job)).To(Succeed())
+			Expect(job.Status.ObservedGeneration).To(Equal(job.Generation))
+			ready := meta.FindStatusCondition(job.Status.Conditions, llmv1alpha1.ConditionReady)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(llmv1alpha1.ReasonInferenceServiceNotFound))
+		})
+	})
+
+	Context("When an InferenceService serves the model", func() {
+		const resourceName = "model-job"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+		const prompt = `it's "quoted"; $(echo injected)`
+
+		BeforeEach(func() {
+			isvc := &llmv1alpha1.InferenceService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "job-target",
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.InferenceServiceSpec{
+					ModelRef: "job-model",
+				},
+			}
+			Expect(k8sClient.Create(ctx, isvc)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, isvc)).To(Succeed())
+			})
+
+			cr := &llmv1alpha1.LLMInferenceJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.LLMInferenceJobSpec{
+					Prompt:                prompt,
+					ModelRef:              "job-model",
+					MaxTokens:             ptr.To(int32(32)),
+					BackoffLimit:          ptr.To(int32(1)),
+					ActiveDeadlineSeconds: ptr.To(int64(600)),
+					APIKeySecretRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "job-keys"},
+						Key:                  "runner",
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cr := &llmv1alpha1.LLMInferenceJob{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cr)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, cr)).To(Succeed())
+
+			// There is no garbage collector in envtest.
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-runner", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))).To(Succeed())
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-output", Namespace: "default"}}
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cm))).To(Succeed())
+		})
+
+		It("should run the runner against that InferenceService", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.InferenceService).To(Equal("job-target"))
+			Expect(meta.FindStatusCondition(cr.Status.Conditions, llmv1alpha1.ConditionProgressing).Reason).
+				To(Equal(llmv1alpha1.ReasonJobRunning))
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+			runner := job.Spec.Template.Spec.Containers[0]
+			Expect(runner.Image).To(Equal(llmv1alpha1.DefaultRunnerImage))
+			Expect(runner.Command).To(BeEmpty())
+			Expect(runner.Env).To(ContainElements(
+				corev1.EnvVar{Name: "ENDPOINT", Value: "http://job-target.default.svc.cluster.local"},
+				corev1.EnvVar{Name: "MODEL", Value: "job-model"},
+				corev1.EnvVar{Name: "PROMPT", Value: prompt},
+				corev1.EnvVar{Name: "MAX_TOKENS", Value: "32"},
+			))
+			Expect(runner.Env).To(ContainElement(HaveField("ValueFrom.SecretKeyRef.Key", "runner")))
+			Expect(*job.Spec.BackoffLimit).To(Equal(int32(1)))
+			Expect(*job.Spec.ActiveDeadlineSeconds).To(Equal(int64(600)))
+			Expect(cr.Status.Phase).To(Equal(llmv1alpha1.JobPhasePending))
+		})
+
+		It("should fail with the runner's termination message", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+
+			By("recording a failed runner pod")
+			createRunnerPod(ctx, &job, corev1.ContainerStateTerminated{
+				ExitCode: 1,
+				Reason:   "Error",
+				Message:  "502 Bad Gateway: backend down",
+			})
+
+			By("failing the Job")
+			now := metav1.Now()
+			job.Status.StartTime = &now
+			job.Status.Failed = 2
+			job.Status.Conditions = []batchv1.JobCondition{
+				{Type: batchv1.JobFailureTarget, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded, LastTransitionTime: now},
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: batchv1.JobReasonBackoffLimitExceeded, LastTransitionTime: now},
+			}
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.Phase).To(Equal(llmv1alpha1.JobPhaseFailed))
+			Expect(cr.Status.Message).To(Equal("502 Bad Gateway: backend down"))
+			ready := meta.FindStatusCondition(cr.Status.Conditions, llmv1alpha1.ConditionReady)
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(llmv1alpha1.ReasonBackoffLimitExceeded))
+		})
+
+		It("should move a long generation out of the status", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+			// Two-byte characters, as long as a termination message gets.
+			long := strings.Repeat("é", 2048)
+			createRunnerPod(ctx, &job, corev1.ContainerStateTerminated{Message: long})
+			job.Status.Succeeded = 1
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.Phase).To(Equal(llmv1alpha1.JobPhaseSucceeded))
+			Expect(cr.Status.OutputTruncated).To(BeTrue())
+			Expect(cr.Status.Output).To(Equal(strings.Repeat("é", 1024)))
+			Expect(cr.Status.OutputRef).To(Equal("configmap://" + resourceName + "-output/output"))
+
+			var cm corev1.ConfigMap
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-output", Namespace: "default"}, &cm)).To(Succeed())
+			Expect(cm.Data).To(HaveKeyWithValue("output", long))
+		})
+
+		It("should read a generation cut by the kubelet back from the runner's logs", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				PodLogs: fake.NewClientset().CoreV1(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+			createRunnerPod(ctx, &job, corev1.ContainerStateTerminated{Message: strings.Repeat("x", 4096)})
+			job.Status.Succeeded = 1
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			// The fake clientset serves every pod's logs as "fake logs".
+			Expect(cr.Status.Output).To(Equal("fake logs"))
+			Expect(cr.Status.OutputTruncated).To(BeFalse())
+		})
+
+		It("should read the output from the logs when the runner left no termination message", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				PodLogs: fake.NewClientset().CoreV1(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			jobName := types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}
+			Expect(k8sClient.Get(ctx, jobName, &job)).To(Succeed())
+			createRunnerPod(ctx, &job, corev1.ContainerStateTerminated{})
+			job.Status.Succeeded = 1
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.Output).To(Equal("fake logs"))
+		})
+
+		It("should delete the runner Job once ttlSecondsAfterFinished has passed", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			cr.Spec.TTLSecondsAfterFinished = ptr.To(int32(0))
+			Expect(k8sClient.Update(ctx, &cr)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			jobName := types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}
+			Expect(k8sClient.Get(ctx, jobName, &job)).To(Succeed())
+			createRunnerPod(ctx, &job, corev1.ContainerStateTerminated{Message: "done"})
+			job.Status.Succeeded = 1
+			Expect(k8sClient.Status().Update(ctx, &job)).To(Succeed())
+
+			// Left over from an earlier, longer generation.
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			output := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-output", Namespace: "default"}}
+			Expect(controllerutil.SetControllerReference(&cr, output, k8sClient.Scheme())).To(Succeed())
+			Expect(k8sClient.Create(ctx, output)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.Phase).To(Equal(llmv1alpha1.JobPhaseSucceeded))
+			Expect(cr.Status.CompletionTime).NotTo(BeNil())
+			Expect(cr.Status.Output).To(Equal("done"))
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, jobName, &job))).To(BeTrue())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(output), output))).To(BeTrue())
+		})
+	})
+
+	Context("When a namespace has more finished jobs than the history limit", func() {
+		const namespace = "job-history"
+
+		ctx := context.Background()
+
+		BeforeEach(func() {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+			Expect(client.IgnoreAlreadyExists(k8sClient.Create(ctx, ns))).To(Succeed())
+
+			for i, name := range []string{"oldest", "older", "newest"} {
+				cr := &llmv1alpha1.LLMInferenceJob{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+					Spec: llmv1alpha1.LLMInferenceJobSpec{
+						ModelRef: "history-model",
+					},
+				}
+				Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+				cr.Status.Phase = llmv1alpha1.JobPhaseSucceeded
+				cr.Status.CompletionTime = ptr.To(metav1.NewTime(time.Now().Add(time.Duration(i-3) * time.Hour)))
+				Expect(k8sClient.Status().Update(ctx, cr)).To(Succeed())
+			}
+		})
+
+		AfterEach(func() {
+			Expect(k8sClient.DeleteAllOf(ctx, &llmv1alpha1.LLMInferenceJob{}, client.InNamespace(namespace))).To(Succeed())
+		})
+
+		It("should delete the oldest ones", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client:       k8sClient,
+				Scheme:       k8sClient.Scheme(),
+				HistoryLimit: 1,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "newest", Namespace: namespace},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var list llmv1alpha1.LLMInferenceJobList
+			Expect(k8sClient.List(ctx, &list, client.InNamespace(namespace))).To(Succeed())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Name).To(Equal("newest"))
+		})
+	})
+
+	Context("When the prompt comes from a Secret", func() {
+		const resourceName = "secret-prompt-job"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			isvc := &llmv1alpha1.InferenceService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "secret-prompt-target",
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.InferenceServiceSpec{
+					ModelRef: "secret-prompt-model",
+				},
+			}
+			Expect(k8sClient.Create(ctx, isvc)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, isvc)).To(Succeed())
+			})
+
+			cr := &llmv1alpha1.LLMInferenceJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.LLMInferenceJobSpec{
+					ModelRef: "secret-prompt-model",
+					PromptFrom: &llmv1alpha1.PromptSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "ticket"},
+							Key:                  "body",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cr := &llmv1alpha1.LLMInferenceJob{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cr)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, cr)).To(Succeed())
+		})
+
+		It("should mount the key into the runner instead of copying it", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+			pod := job.Spec.Template.Spec
+			Expect(pod.Volumes).To(HaveLen(1))
+			Expect(pod.Volumes[0].Secret.SecretName).To(Equal("ticket"))
+			Expect(pod.Volumes[0].Secret.Items).To(Equal([]corev1.KeyToPath{{Key: "body", Path: "prompt"}}))
+			runner := pod.Containers[0]
+			Expect(runner.Env).To(ContainElement(corev1.EnvVar{Name: "PROMPT_FILE", Value: "/prompt/prompt"}))
+			Expect(runner.Env).NotTo(ContainElement(HaveField("Name", "PROMPT")))
+		})
+
+		It("should reject a job that also sets prompt", func() {
+			cr := &llmv1alpha1.LLMInferenceJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "two-prompts",
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.LLMInferenceJobSpec{
+					ModelRef: "secret-prompt-model",
+					Prompt:   "hello",
+					PromptFrom: &llmv1alpha1.PromptSource{
+						ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "prompts"},
+							Key:                  "hello",
+						},
+					},
+				},
+			}
+			err := k8sClient.Create(ctx, cr)
+			Expect(errors.IsInvalid(err)).To(BeTrue(), "err = %v", err)
+		})
+	})
+
+	Context("When the job is a batch", func() {
+		const resourceName = "batch-job"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		BeforeEach(func() {
+			isvc := &llmv1alpha1.InferenceService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "batch-target",
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.InferenceServiceSpec{
+					ModelRef: "batch-model",
+				},
+			}
+			Expect(k8sClient.Create(ctx, isvc)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, isvc)).To(Succeed())
+			})
+
+			cr := &llmv1alpha1.LLMInferenceJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: llmv1alpha1.LLMInferenceJobSpec{
+					InferenceServiceRef: "batch-target",
+					Batch: &llmv1alpha1.LLMInferenceBatch{
+						Prompts: []string{"one", "two", `three "quoted"`},
+						Output: llmv1alpha1.BatchVolumeFile{
+							ClaimName: "results",
+							Path:      "run-1",
+						},
+						Shards:      3,
+						Parallelism: ptr.To(int32(2)),
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			cr := &llmv1alpha1.LLMInferenceJob{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, cr)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, cr)).To(Succeed())
+		})
+
+		It("should shard the prompts across an indexed Job", func() {
+			controllerReconciler := &LLMInferenceJobReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			var cm corev1.ConfigMap
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-prompts", Namespace: "default"}, &cm)).To(Succeed())
+			Expect(cm.Data).To(HaveKeyWithValue("prompts.jsonl", `"one"
+"two"
+"three \"quoted\""
+`))
+
+			var job batchv1.Job
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: resourceName + "-runner", Namespace: "default"}, &job)).To(Succeed())
+			Expect(*job.Spec.CompletionMode).To(Equal(batchv1.IndexedCompletion))
+			Expect(*job.Spec.Completions).To(Equal(int32(3)))
+			Expect(*job.Spec.Parallelism).To(Equal(int32(2)))
+			Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElements(
+				corev1.EnvVar{Name: "BATCH_INPUT", Value: "/input/prompts.jsonl"},
+				corev1.EnvVar{Name: "BATCH_OUTPUT", Value: "/output/run-1"},
+				corev1.EnvVar{Name: "BATCH_SHARDS", Value: "3"},
+			))
+
+			var cr llmv1alpha1.LLMInferenceJob
+			Expect(k8sClient.Get(ctx, typeNamespacedName, &cr)).To(Succeed())
+			Expect(cr.Status.Shards).To(HaveLen(3))
+			Expect(cr.Status.Shards[2]).To(Equal(llmv1alpha1.LLMInferenceShardStatus{
+				Index:  2,
+				Phase:  llmv1alpha1.ShardPending,
+				Output: "pvc://results/run-1/shard-2.jsonl",
+			}))
 		})
 	})
 })
+
+// createRunnerPod records a pod of job whose runner container terminated in
+// state, as the Job controller and kubelet would.
+func createRunnerPod(ctx context.Context, job *batchv1.Job, state corev1.ContainerStateTerminated) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: job.Name + "-",
+			Namespace:    job.Namespace,
+			Labels:       map[string]string{batchv1.JobNameLabel: job.Name},
+		},
+		Spec: job.Spec.Template.Spec,
+	}
+	Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+	DeferCleanup(func() {
+		Expect(k8sClient.Delete(ctx, pod)).To(Succeed())
+	})
+
+	pod.Status.Phase = corev1.PodSucceeded
+	if state.ExitCode != 0 {
+		pod.Status.Phase = corev1.PodFailed
+	}
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "runner",
+		State: corev1.ContainerState{Terminated: &state},
+	}}
+	Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+// collect cleans up after a finished job: it enforces the namespace's
+// history limit and cr's ttlSecondsAfterFinished, requeueing until the TTL
+// has passed.
+func (r *LLMInferenceJobReconciler) collect(ctx context.Context, cr *llmv1alpha1.LLMInferenceJob) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if cr.Status.CompletionTime == nil {
+		// Finished before completion times were recorded; count from now.
+		orig := cr.Status.DeepCopy()
+		cr.Status.CompletionTime = ptr.To(metav1.Now())
+		if err := r.updateStatus(ctx, cr, orig); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if deleted, err := r.pruneHistory(ctx, cr); err != nil || deleted {
+		return ctrl.Result{}, err
+	}
+
+	ttl := cr.Spec.TTLSecondsAfterFinished
+	if ttl == nil {
+		return ctrl.Result{}, nil
+	}
+	expiry := cr.Status.CompletionTime.Add(time.Duration(*ttl) * time.Second)
+	if remaining := time.Until(expiry); remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	if cr.Spec.DeleteAfterTTL {
+		log.Info("deleting LLMInferenceJob after ttlSecondsAfterFinished")
+		err := r.Delete(ctx, cr, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if err := r.deleteOwned(ctx, cr, &batchv1.Job{}, cr.Name+"-runner"); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.deleteOwned(ctx, cr, &corev1.ConfigMap{}, promptsConfigMapName(cr)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.deleteOwned(ctx, cr, &corev1.ConfigMap{}, outputConfigMapName(cr)); err != nil {
+		return ctrl.Result{}, err
+	}
+	// The start of the output stays in status.output; do not point at
+	// the ConfigMap that is gone.
+	if strings.HasPrefix(cr.Status.OutputRef, outputConfigMapRefPrefix) {
+		orig := cr.Status.DeepCopy()
+		cr.Status.OutputRef = ""
+		return ctrl.Result{}, r.updateStatus(ctx, cr, orig)
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteOwned deletes the named object if it exists and is controlled by cr.
+// Dependents, such as a Job's pods, are deleted in the background.
+func (r *LLMInferenceJobReconciler) deleteOwned(ctx context.Context, cr *llmv1alpha1.LLMInferenceJob,
+	obj client.Object, name string) error {
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: cr.Namespace}, obj)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, cr) {
+		return nil
+	}
+	if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	ctrl.LoggerFrom(ctx).Info("deleted finished job's object after ttlSecondsAfterFinished",
+		"kind", fmt.Sprintf("%T", obj), "name", name)
+	return nil
+}
+
+// pruneHistory deletes the oldest finished LLMInferenceJobs in cr's
+// namespace beyond HistoryLimit. It reports whether cr itself was deleted.
+func (r *LLMInferenceJobReconciler) pruneHistory(ctx context.Context, cr *llmv1alpha1.LLMInferenceJob) (bool, error) {
+	if r.HistoryLimit <= 0 {
+		return false, nil
+	}
+
+	var list llmv1alpha1.LLMInferenceJobList
+	if err := r.List(ctx, &list, client.InNamespace(cr.Namespace)); err != nil {
+		return false, err
+	}
+	var done []*llmv1alpha1.LLMInferenceJob
+	for i := range list.Items {
+		if j := &list.Items[i]; finished(j) && j.DeletionTimestamp == nil {
+			done = append(done, j)
+		}
+	}
+	if len(done) <= r.HistoryLimit {
+		return false, nil
+	}
+
+	// Oldest first.
+	slices.SortFunc(done, func(a, b *llmv1alpha1.LLMInferenceJob) int {
+		if c := finishedAt(a).Compare(finishedAt(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	deletedSelf := false
+	for _, j := range done[:len(done)-r.HistoryLimit] {
+		err := r.Delete(ctx, j, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return deletedSelf, err
+		}
+		ctrl.LoggerFrom(ctx).Info("deleted LLMInferenceJob beyond the history limit",
+			"name", j.Name, "historyLimit", r.HistoryLimit)
+		deletedSelf = deletedSelf || j.UID == cr.UID
+	}
+	return deletedSelf, nil
+}
+
+// finishedAt is when j finished, or when it was created if that was not
+// recorded.
+func finishedAt(j *llmv1alpha1.LLMInferenceJob) time.Time {
+	if t := j.Status.CompletionTime; t != nil {
+		return t.Time
+	}
+	return j.CreationTimestamp.Time
+}
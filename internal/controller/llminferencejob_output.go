@@ -0,0 +1,121 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+const (
+	// terminationMessageLimit is how much of a termination message the
+	// kubelet keeps. A message this long may have been cut.
+	terminationMessageLimit = 4096
+
+	// maxStatusOutputBytes caps status.output so long generations do not
+	// bloat etcd; longer text goes to a ConfigMap.
+	maxStatusOutputBytes = 2 << 10
+
+	// maxOutputConfigMapBytes caps the output ConfigMap well below the
+	// 1 MiB object size limit.
+	maxOutputConfigMapBytes = 512 << 10
+
+	// outputKey is the key of the output ConfigMap holding the text.
+	outputKey = "output"
+
+	// outputConfigMapRefPrefix starts status.outputRef when it points at
+	// the output ConfigMap.
+	outputConfigMapRefPrefix = "configmap://"
+)
+
+// outputConfigMapName names the ConfigMap holding a long generation.
+func outputConfigMapName(cr *llmv1alpha1.LLMInferenceJob) string {
+	return cr.Name + "-output"
+}
+
+// recordOutput copies the succeeded runner's generated text into cr's
+// status. Text longer than maxStatusOutputBytes is written in full to an
+// owned ConfigMap and only its start is kept in the status.
+func (r *LLMInferenceJobReconciler) recordOutput(ctx context.Context, cr *llmv1alpha1.LLMInferenceJob,
+	pods []corev1.Pod) error {
+	pod, out := runnerOutput(pods)
+	// An empty message may also mean the runner could not write it.
+	if pod != nil && (out == "" || len(out) >= terminationMessageLimit) {
+		out = r.runnerLogs(ctx, pod, out)
+	}
+
+	cr.Status.Output, cr.Status.OutputTruncated, cr.Status.OutputRef = out, false, ""
+	if len(out) <= maxStatusOutputBytes {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      outputConfigMapName(cr),
+			Namespace: cr.Namespace,
+		},
+		Data: map[string]string{
+			outputKey: truncateUTF8(out, maxOutputConfigMapBytes),
+		},
+	}
+	if err := applyOwned(ctx, r.Client, r.Scheme, cr, cm); err != nil {
+		return err
+	}
+	cr.Status.Output = truncateUTF8(out, maxStatusOutputBytes)
+	cr.Status.OutputTruncated = true
+	cr.Status.OutputRef = outputConfigMapRefPrefix + cm.Name + "/" + outputKey
+	return nil
+}
+
+// runnerLogs reads a generation too long for pod's termination message back
+// from the runner's stdout. It returns msg, the termination message, if the
+// logs cannot be read. A succeeding runner writes nothing to stderr, so the
+// logs hold only the text.
+func (r *LLMInferenceJobReconciler) runnerLogs(ctx context.Context, pod *corev1.Pod, msg string) string {
+	if r.PodLogs == nil {
+		return msg
+	}
+	data, err := r.PodLogs.Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container:  runnerContainer,
+		LimitBytes: ptr.To(int64(maxOutputConfigMapBytes)),
+	}).DoRaw(ctx)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "failed to read runner logs, keeping the termination message", "pod", pod.Name)
+		return msg
+	}
+	// The runner prints the text followed by a newline.
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+const (
+	// DefaultMaxPromptBytes is the default limit on a single prompt. Prompts
+	// reach the runner in an environment variable, and Linux refuses to
+	// exec with one longer than 128 KiB.
+	DefaultMaxPromptBytes = 64 << 10
+
+	// maxInlinePromptsBytes limits spec.batch.prompts as a whole. Inline
+	// prompts are copied into a ConfigMap, which is capped at 1 MiB; larger
+	// batches belong in a ConfigMap of their own or on a claim.
+	maxInlinePromptsBytes = 512 << 10
+)
+
+// log is for logging in this package.
+var llminferencejoblog = logf.Log.WithName("llminferencejob-resource")
+
+// SetupLLMInferenceJobWebhookWithManager registers the webhook for LLMInferenceJob in the manager.
+// Prompts longer than maxPromptBytes are rejected.
+func SetupLLMInferenceJobWebhookWithManager(mgr ctrl.Manager, maxPromptBytes int) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&llmv1alpha1.LLMInferenceJob{}).
+		WithValidator(&LLMInferenceJobCustomValidator{MaxPromptBytes: maxPromptBytes}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-llm-example-com-v1alpha1-llminferencejob,mutating=false,failurePolicy=fail,sideEffects=None,groups=llm.example.com,resources=llminferencejobs,verbs=create;update,versions=v1alpha1,name=vllminferencejob-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// LLMInferenceJobCustomValidator struct is responsible for validating the LLMInferenceJob resource
+// when it is created, updated, or deleted.
+//
+// Prompts are untrusted user text; the validator bounds their size so a
+// single job cannot break its runner pod or bloat etcd.
+type LLMInferenceJobCustomValidator struct {
+	// MaxPromptBytes limits spec.prompt and every entry of
+	// spec.batch.prompts. Zero means DefaultMaxPromptBytes.
+	MaxPromptBytes int
+}
+
+var _ webhook.CustomValidator = &LLMInferenceJobCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type LLMInferenceJob.
+func (v *LLMInferenceJobCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	llminferencejob, ok := obj.(*llmv1alpha1.LLMInferenceJob)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMInferenceJob object but got %T", obj)
+	}
+	llminferencejoblog.Info("Validation for LLMInferenceJob upon creation", "name", llminferencejob.GetName())
+
+	return nil, v.validate(llminferencejob)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type LLMInferenceJob.
+func (v *LLMInferenceJobCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	llminferencejob, ok := newObj.(*llmv1alpha1.LLMInferenceJob)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMInferenceJob object for the newObj but got %T", newObj)
+	}
+	llminferencejoblog.Info("Validation for LLMInferenceJob upon update", "name", llminferencejob.GetName())
+
+	return nil, v.validate(llminferencejob)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type LLMInferenceJob.
+func (v *LLMInferenceJobCustomValidator) ValidateDelete(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate returns an Invalid error listing every prompt that is too long.
+func (v *LLMInferenceJobCustomValidator) validate(job *llmv1alpha1.LLMInferenceJob) error {
+	limit := v.MaxPromptBytes
+	if limit <= 0 {
+		limit = DefaultMaxPromptBytes
+	}
+
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	if len(job.Spec.Prompt) > limit {
+		errs = append(errs, field.TooLong(spec.Child("prompt"), "", limit))
+	}
+	if job.Spec.Batch != nil {
+		prompts := spec.Child("batch", "prompts")
+		total := 0
+		for i, p := range job.Spec.Batch.Prompts {
+			if len(p) > limit {
+				errs = append(errs, field.TooLong(prompts.Index(i), "", limit))
+			}
+			total += len(p)
+		}
+		if total > maxInlinePromptsBytes {
+			errs = append(errs, field.TooLong(prompts, "", maxInlinePromptsBytes))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(llmv1alpha1.GroupVersion.WithKind("LLMInferenceJob").GroupKind(), job.Name, errs)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	llmv1alpha1 "github.com/vishalsanfran/llama-shepherd/api/v1alpha1"
+)
+
+var _ = Describe("LLMInferenceJob Webhook", func() {
+	var (
+		obj       *llmv1alpha1.LLMInferenceJob
+		oldObj    *llmv1alpha1.LLMInferenceJob
+		validator LLMInferenceJobCustomValidator
+	)
+
+	BeforeEach(func() {
+		obj = &llmv1alpha1.LLMInferenceJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-job", Namespace: "default"},
+			Spec: llmv1alpha1.LLMInferenceJobSpec{
+				ModelRef: "dummy-model",
+				Prompt:   "it's a prompt; $(rm -rf /)",
+			},
+		}
+		oldObj = obj.DeepCopy()
+		validator = LLMInferenceJobCustomValidator{MaxPromptBytes: 16}
+	})
+
+	Context("When creating or updating LLMInferenceJob under Validating Webhook", func() {
+		It("Should admit a prompt within the limit whatever it contains", func() {
+			validator.MaxPromptBytes = 0
+			Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
+		})
+
+		It("Should deny creation if the prompt is too long", func() {
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.prompt"))
+		})
+
+		It("Should deny an update that makes the prompt too long", func() {
+			oldObj.Spec.Prompt = "short"
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		})
+
+		It("Should check every inline batch prompt and their total size", func() {
+			obj.Spec.Prompt = ""
+			obj.Spec.Batch = &llmv1alpha1.LLMInferenceBatch{
+				Prompts: []string{"short", strings.Repeat("x", 17)},
+			}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.batch.prompts[1]")))
+
+			validator.MaxPromptBytes = DefaultMaxPromptBytes
+			obj.Spec.Batch.Prompts = make([]string, maxInlinePromptsBytes/DefaultMaxPromptBytes+1)
+			for i := range obj.Spec.Batch.Prompts {
+				obj.Spec.Batch.Prompts[i] = strings.Repeat("x", DefaultMaxPromptBytes)
+			}
+			_, err = validator.ValidateCreate(ctx, obj)
+			Expect(err).To(MatchError(ContainSubstring("spec.batch.prompts: Too long")))
+		})
+
+		It("Should reject an oversized prompt through the API server", func() {
+			obj.Spec.Prompt = strings.Repeat("x", DefaultMaxPromptBytes+1)
+			err := k8sClient.Create(ctx, obj)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "err = %v", err)
+		})
+	})
+})